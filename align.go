@@ -0,0 +1,130 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The subfield ID used for alignment padding in the header's Extra field.
+const (
+	paddingSI1 = 'P'
+	paddingSI2 = 'D'
+)
+
+// WithMemberAlignment pads every gzip member to a multiple of n bytes, so that
+// each member starts at an n-byte aligned offset in the output (assuming the
+// output itself starts at an aligned offset). This suits storage systems that
+// prefer block-aligned records.
+//
+// The padding is a 'PD' subfield in the header's Extra field, made of zero
+// bytes, which decoders ignore, so the output is still standard gzip. Since
+// the padding depends on the member's final compressed size, each member is
+// held in memory until it is finished by NextMember, FlushMember, or Close,
+// and only then written to the underlying writer. Flush does not write out
+// anything before that.
+//
+// If the padding would make the Extra field larger than 0xffff bytes, the
+// member fails with ErrHdrExtaDataTooLarge. A value of 0 or less disables
+// alignment, which is the default.
+func WithMemberAlignment(n int) Option {
+	return func(o *writerOptions) {
+		o.memberAlignment = n
+	}
+}
+
+// alignedOutput holds the current member while WithMemberAlignment is set,
+// until it is finished and can be padded.
+type alignedOutput struct {
+	dst    io.Writer
+	member bytes.Buffer
+}
+
+// writeAlignedMember pads the finished member held in z.aligned, and writes it
+// out to the underlying writer.
+func (z *GzipStreamWriter) writeAlignedMember() error {
+	defer z.aligned.member.Reset()
+
+	pieces, err := padMember(z.aligned.member.Bytes(), z.options.memberAlignment)
+	if err != nil {
+		z.err = err
+		return z.err
+	}
+	// The member itself was already counted on its way into the buffer, so
+	// only the padding is added.
+	z.w.n -= int64(z.aligned.member.Len())
+	for _, piece := range pieces {
+		var n int
+		n, z.err = z.aligned.dst.Write(piece)
+		z.w.n += int64(n)
+		if z.err != nil {
+			return z.err
+		}
+	}
+	return nil
+}
+
+// alignmentPadding returns how many bytes padMember will add to the current
+// member, once pending more bytes have been written to it. Without
+// WithMemberAlignment, it is 0.
+func (z *GzipStreamWriter) alignmentPadding(pending int64) int64 {
+	if z.aligned == nil {
+		return 0
+	}
+	member := z.aligned.member.Bytes()
+	hasExtra := z.Extra != nil
+	if len(member) > 3 {
+		hasExtra = member[3]&flagExtra != 0
+	}
+	// Mirrors the arithmetic in padMember.
+	added := int64(4)
+	if !hasExtra {
+		added += 2
+	}
+	unpadded := int64(len(member)) + pending + added
+	alignment := int64(z.options.memberAlignment)
+	return added + (alignment-unpadded%alignment)%alignment
+}
+
+// padMember returns the pieces of a complete gzip member, with a padding
+// subfield inserted at the end of its header's Extra field, such that the
+// padded member's length is a multiple of alignment. The first two pieces
+// are new (the start of the header, and the padding subfield), and the last
+// one is the rest of member, unmodified.
+func padMember(member []byte, alignment int) ([3][]byte, error) {
+	var head []byte
+	var extraLength int
+	if member[3]&flagExtra != 0 {
+		extraLength = int(binary.LittleEndian.Uint16(member[10:12]))
+		head = bytes.Clone(member[:12+extraLength])
+	} else {
+		// Add an empty Extra field, for the padding subfield to go in.
+		head = make([]byte, 12)
+		copy(head, member[:10])
+		head[3] |= flagExtra
+	}
+	rest := member[12+extraLength:]
+	if member[3]&flagExtra == 0 {
+		rest = member[10:]
+	}
+
+	// The padding subfield has a 4 byte header, plus the padding itself.
+	unpadded := len(head) + len(rest) + 4
+	padding := (alignment - unpadded%alignment) % alignment
+	extraLength += 4 + padding
+	if extraLength > 0xffff {
+		return [3][]byte{}, fmt.Errorf("%w: %d bytes needed for alignment padding", ErrHdrExtaDataTooLarge, extraLength)
+	}
+	binary.LittleEndian.PutUint16(head[10:12], uint16(extraLength))
+
+	subfield := make([]byte, 4+padding)
+	subfield[0] = paddingSI1
+	subfield[1] = paddingSI2
+	binary.LittleEndian.PutUint16(subfield[2:4], uint16(padding))
+	return [3][]byte{head, subfield, rest}, nil
+}
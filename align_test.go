@@ -0,0 +1,139 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestWithMemberAlignment(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(100_000)
+	blob := compressStdlib(t, []byte("hello, world!\n"))
+
+	testCases := []struct {
+		name      string
+		alignment int
+		extra     []byte
+	}{
+		{name: "512 bytes", alignment: 512},
+		{name: "4 KB", alignment: 4096},
+		{name: "1 byte", alignment: 1},
+		{name: "existing Extra field", alignment: 512, extra: []byte{'A', 'B', 3, 0, 'x', 'y', 'z'}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var expected []byte
+			buffer := bytes.Buffer{}
+			gzWriter := gzipstreamwriter.NewGzipStreamWriter(&buffer, gzipstreamwriter.WithMemberAlignment(tc.alignment))
+			gzWriter.Extra = tc.extra
+			for i := range 3 {
+				chunk := input[i*20_000 : (i+1)*20_000]
+				if _, err := gzWriter.Write(chunk); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if _, err := gzWriter.WriteCompressed(blob); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				expected = append(expected, chunk...)
+				expected = append(expected, "hello, world!\n"...)
+				if i < 2 {
+					if err := gzWriter.NextMember(); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+				}
+			}
+			if err := gzWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// Every member is padded to a multiple of the alignment.
+			members, err := gzipstreamwriter.SplitMembers(buffer.Bytes())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(members) != 3 {
+				t.Fatalf("expected 3 members, got %d", len(members))
+			}
+			for i, member := range members {
+				if len(member)%tc.alignment != 0 {
+					t.Fatalf("member %d: expected length aligned to %d, got %d", i, tc.alignment, len(member))
+				}
+			}
+			if gzWriter.EstimatedSize() != int64(buffer.Len()) {
+				t.Fatalf("expected size %d, got %d", buffer.Len(), gzWriter.EstimatedSize())
+			}
+
+			gzReader, err := gzip.NewReader(bytes.NewReader(buffer.Bytes()))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// The original subfields come before the padding.
+			if tc.extra != nil && !bytes.HasPrefix(gzReader.Extra, tc.extra) {
+				t.Fatalf("expected Extra field to start with %v, got %v", tc.extra, gzReader.Extra[:len(tc.extra)])
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestWithMemberAlignment() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("estimated size includes padding", func(t *testing.T) {
+		t.Parallel()
+
+		for _, extra := range [][]byte{nil, {'A', 'B', 3, 0, 'x', 'y', 'z'}} {
+			buffer := bytes.Buffer{}
+			gzWriter := gzipstreamwriter.NewGzipStreamWriter(&buffer, gzipstreamwriter.WithMemberAlignment(512))
+			gzWriter.Extra = extra
+			estimated := gzWriter.EstimatedSize()
+			if _, err := gzWriter.WriteCompressed(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// With only blobs written, the estimate is exact before Close.
+			estimatedBlob := gzWriter.EstimatedSize()
+			if err := gzWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if estimated != 512 || estimatedBlob != 512 {
+				t.Fatalf("expected size 512 before and after the blob, got %d and %d", estimated, estimatedBlob)
+			}
+			if buffer.Len() != 512 {
+				t.Fatalf("expected size 512, got %d", buffer.Len())
+			}
+		}
+	})
+
+	t.Run("output limit includes padding", func(t *testing.T) {
+		t.Parallel()
+
+		gzWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.WithMemberAlignment(512), gzipstreamwriter.WithMaxOutputBytes(100))
+		if _, err := gzWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected ErrOutputLimitExceeded, got %v", err)
+		}
+	})
+
+	t.Run("padding too large", func(t *testing.T) {
+		t.Parallel()
+
+		gzWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.WithMemberAlignment(1<<20))
+		if _, err := gzWriter.Write([]byte("hello, world!\n")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := gzWriter.Close(); !errors.Is(err, gzipstreamwriter.ErrHdrExtaDataTooLarge) {
+			t.Fatalf("expected ErrHdrExtaDataTooLarge, got %v", err)
+		}
+	})
+}
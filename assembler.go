@@ -0,0 +1,236 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+)
+
+// PositionalBlobAssembler writes compressed gzip blobs to a single gzip
+// stream in index order, for producers that finish their blobs out of order.
+//
+// Each blob is submitted with Put, along with its final position in the
+// stream. Blobs are written as soon as every blob before them has been
+// written; until then, they are held in memory. It is safe to call Put from
+// multiple goroutines.
+type PositionalBlobAssembler struct {
+	mu      sync.Mutex
+	z       *GzipStreamWriter
+	next    int            // Index of the next blob to write.
+	waiting map[int][]byte // Blobs that arrived before their predecessors.
+	err     error
+
+	// The OnMember callback, if any, and its calls that are waiting for mu
+	// to be released.
+	onMember func(index, compressedLen int, checksum uint32)
+	events   []memberEventArgs
+}
+
+// memberEventArgs holds the arguments of a call to an OnMember callback.
+type memberEventArgs struct {
+	index, compressedLen int
+	checksum             uint32
+}
+
+// NewPositionalBlobAssembler creates a new PositionalBlobAssembler that
+// writes a single gzip stream at the default compression level to w.
+// Indexes start at 0.
+func NewPositionalBlobAssembler(w io.Writer, opts ...Option) *PositionalBlobAssembler {
+	a := &PositionalBlobAssembler{
+		z:       NewGzipStreamWriter(w, opts...),
+		waiting: make(map[int][]byte),
+	}
+	if onMember := a.z.options.onMember; onMember != nil {
+		// Queue up the calls, so that they can run once mu is released.
+		a.onMember = onMember
+		a.z.options.onMember = func(index, compressedLen int, checksum uint32) {
+			a.events = append(a.events, memberEventArgs{index, compressedLen, checksum})
+		}
+	}
+	return a
+}
+
+// unlock releases a.mu, and then runs the OnMember calls queued up while it
+// was held.
+func (a *PositionalBlobAssembler) unlock() {
+	events := a.events
+	a.events = nil
+	a.mu.Unlock()
+	for _, e := range events {
+		a.onMember(e.index, e.compressedLen, e.checksum)
+	}
+}
+
+// Put submits the blob for position index. If every blob before it has been
+// written, it is written immediately, along with any held blobs that directly
+// follow it. Otherwise, it is held until its predecessors arrive, by
+// reference, so the caller must not reuse its memory until Close returns.
+//
+// An index that was already submitted, or is negative, returns an error
+// wrapping ErrBlobIndex. If writing a blob fails, the error is sticky, and is
+// returned from all later calls. Once Close has succeeded, Put returns
+// ErrClosed.
+func (a *PositionalBlobAssembler) Put(index int, blob []byte) error {
+	a.mu.Lock()
+	defer a.unlock()
+
+	if a.err != nil {
+		return a.err
+	}
+	if a.z.Closed() {
+		return ErrClosed
+	}
+	if index < a.next {
+		return fmt.Errorf("%w: %d", ErrBlobIndex, index)
+	}
+	if _, ok := a.waiting[index]; ok {
+		return fmt.Errorf("%w: %d", ErrBlobIndex, index)
+	}
+	a.waiting[index] = blob
+
+	// Write out the contiguous run of blobs from the next index, if any.
+	for {
+		blob, ok := a.waiting[a.next]
+		if !ok {
+			return nil
+		}
+		delete(a.waiting, a.next)
+		if _, err := a.z.WriteCompressed(blob); err != nil {
+			a.err = fmt.Errorf("gzip: failed to write blob %d: %w", a.next, err)
+			return a.err
+		}
+		a.next++
+	}
+}
+
+// Close writes the gzip trailer, and finishes the stream.
+// It does not close the underlying writer.
+//
+// If any blobs are still held because of a gap in the indexes, Close returns
+// an error wrapping ErrMissingBlobs, and no trailer is written.
+func (a *PositionalBlobAssembler) Close() error {
+	a.mu.Lock()
+	defer a.unlock()
+
+	if a.err != nil {
+		return a.err
+	}
+	if len(a.waiting) > 0 {
+		return fmt.Errorf("%w: blob %d was never submitted, %d later blobs pending", ErrMissingBlobs, a.next, len(a.waiting))
+	}
+	return a.z.Close()
+}
+
+// BufferedBlobAssembler concatenates compressed gzip blobs into a single gzip
+// stream held in memory, for callers that want the result as a []byte rather
+// than streamed to an io.Writer. The trailer is only written once the stream
+// is asked for, with Bytes.
+type BufferedBlobAssembler struct {
+	buf *bytes.Buffer
+	z   *GzipStreamWriter
+}
+
+// NewBufferedBlobAssembler creates a new BufferedBlobAssembler that appends a
+// single gzip stream at the default compression level to dst, which may be
+// nil. The assembler takes ownership of dst.
+func NewBufferedBlobAssembler(dst []byte, opts ...Option) *BufferedBlobAssembler {
+	buf := bytes.NewBuffer(dst)
+	return &BufferedBlobAssembler{
+		buf: buf,
+		z:   NewGzipStreamWriter(buf, opts...),
+	}
+}
+
+// AppendBlob splices blob into the stream, like WriteCompressed does.
+// Once Bytes has been called, it returns ErrClosed.
+func (a *BufferedBlobAssembler) AppendBlob(blob []byte) error {
+	if a.z.Closed() {
+		return ErrClosed
+	}
+	_, err := a.z.WriteCompressed(blob)
+	return err
+}
+
+// Bytes writes the trailer, and returns the finished stream, appended to the
+// dst slice passed to NewBufferedBlobAssembler. Later calls return the same
+// stream. If an AppendBlob call left the stream in an error state, Bytes
+// returns nil.
+func (a *BufferedBlobAssembler) Bytes() []byte {
+	if err := a.z.Close(); err != nil {
+		return nil
+	}
+	return a.buf.Bytes()
+}
+
+// AppendBlobs concatenates the compressed gzip blobs into a single gzip
+// stream, like AssembleGolden, and appends it to dst, in the style of the
+// append built-in. It returns the extended slice. Callers that assemble many
+// streams can pass the same scratch buffer in each time, to reuse its memory.
+//
+// Every blob is checked and prepared for splicing first, so that dst is grown
+// at most once, to the exact size of the stream. An invalid blob returns an
+// error wrapping ErrBlob, naming the blob's index, and dst is returned as-is.
+func AppendBlobs(dst []byte, blobs [][]byte) ([]byte, error) {
+	bufs := make([][spliceTailSize + 1]byte, len(blobs))
+	pieces := make([][4][]byte, len(blobs))
+	var digest, size uint32
+	length := 0
+	for i, blob := range blobs {
+		content, checksum, isize, err := TrimBlob(blob)
+		if err != nil {
+			return dst, fmt.Errorf("blob %d: %w", i, err)
+		}
+		if pieces[i], err = prepareDeflate(&bufs[i], content, checksum, isize); err != nil {
+			return dst, fmt.Errorf("blob %d: %w", i, err)
+		}
+		length += piecesLength(pieces[i][:])
+		digest = crc32Combine(crc32.IEEETable, digest, checksum, int(isize))
+		size += isize
+	}
+
+	// The default header cannot fail validation.
+	header, _ := buildHeader(gzip.Header{OS: 255}, xflForLevel(DefaultCompression), false)
+	final := emptyFinalBlockFor(DefaultCompression)
+	dst = slices.Grow(dst, len(header)+length+len(final)+8)
+	dst = append(dst, header...)
+	for _, p := range pieces {
+		for _, piece := range p {
+			dst = append(dst, piece...)
+		}
+	}
+	dst = append(dst, final...)
+	dst = binary.LittleEndian.AppendUint32(dst, digest)
+	dst = binary.LittleEndian.AppendUint32(dst, size)
+	return dst, nil
+}
+
+// AssembleGolden concatenates blobs into a single gzip stream, exactly as a
+// GzipStreamWriter with the default settings would, by passing each blob to
+// WriteCompressed in order. It exists to generate reference ("golden")
+// outputs, which other implementations can be compared against.
+//
+// The empty final block before the trailer is written by compress/flate, and
+// its encoding has changed between Go versions, so the output is only
+// byte-for-byte stable for a given Go toolchain.
+func AssembleGolden(blobs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	z := NewGzipStreamWriter(&buf)
+	for i, blob := range blobs {
+		if _, err := z.WriteCompressed(blob); err != nil {
+			return nil, fmt.Errorf("blob %d: %w", i, err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,420 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestPositionalBlobAssembler(t *testing.T) {
+	t.Parallel()
+
+	blobs := make([][]byte, 8)
+	for i := range blobs {
+		blobs[i] = compressStdlib(t, []byte(fmt.Sprintf("blob number %d\n", i)))
+	}
+
+	// The expected output is the same blobs, written in order.
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	for _, blob := range blobs {
+		if _, err := expGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := expGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("out of order puts", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(&actBuffer)
+		for _, i := range []int{3, 1, 0, 2, 7, 5, 4, 6} {
+			if err := actAssembler.Put(i, blobs[i]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actAssembler.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestPositionalBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("blobs are written once contiguous", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(&actBuffer)
+		if err := actAssembler.Put(1, blobs[1]); err != nil {
+			t.Fatal(err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected no output before blob 0, got %d bytes", actBuffer.Len())
+		}
+		if err := actAssembler.Put(0, blobs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if actBuffer.Len() == 0 {
+			t.Fatalf("expected output after blob 0, got none")
+		}
+	})
+
+	t.Run("concurrent puts", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(&actBuffer)
+		var wg sync.WaitGroup
+		for i := len(blobs) - 1; i >= 0; i-- {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := actAssembler.Put(i, blobs[i]); err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if err := actAssembler.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestPositionalBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("gaps fail on close", func(t *testing.T) {
+		t.Parallel()
+
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(io.Discard)
+		for _, i := range []int{0, 1, 3} {
+			if err := actAssembler.Put(i, blobs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := actAssembler.Close(); !errors.Is(err, gzipstreamwriter.ErrMissingBlobs) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrMissingBlobs, err)
+		}
+	})
+
+	t.Run("repeated and negative indexes", func(t *testing.T) {
+		t.Parallel()
+
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(io.Discard)
+		for _, i := range []int{0, 2} {
+			if err := actAssembler.Put(i, blobs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, i := range []int{-1, 0, 2} {
+			if err := actAssembler.Put(i, blobs[1]); !errors.Is(err, gzipstreamwriter.ErrBlobIndex) {
+				t.Fatalf("index %d: expected error %v, got %v", i, gzipstreamwriter.ErrBlobIndex, err)
+			}
+		}
+	})
+
+	t.Run("put after close", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(&actBuffer)
+		if err := actAssembler.Put(0, blobs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := actAssembler.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		size := actBuffer.Len()
+		if err := actAssembler.Put(1, blobs[1]); !errors.Is(err, gzipstreamwriter.ErrClosed) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrClosed, err)
+		}
+		if actBuffer.Len() != size {
+			t.Fatalf("expected %d bytes of output, got %d bytes", size, actBuffer.Len())
+		}
+		if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("invalid blobs are sticky", func(t *testing.T) {
+		t.Parallel()
+
+		actAssembler := gzipstreamwriter.NewPositionalBlobAssembler(io.Discard)
+		if err := actAssembler.Put(0, []byte("not a gzip blob")); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if err := actAssembler.Put(1, blobs[1]); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if err := actAssembler.Close(); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestPositionalBlobAssemblerOnMember(t *testing.T) {
+	t.Parallel()
+
+	var actAssembler *gzipstreamwriter.PositionalBlobAssembler
+	var indexes []int
+	onMember := gzipstreamwriter.OnMember(func(index, _ int, _ uint32) {
+		indexes = append(indexes, index)
+		// Calling back into the assembler must not deadlock. The last call
+		// comes from Close, after which Put is rejected outright.
+		expErr := gzipstreamwriter.ErrBlobIndex
+		if index == 3 {
+			expErr = gzipstreamwriter.ErrClosed
+		}
+		if err := actAssembler.Put(0, nil); !errors.Is(err, expErr) {
+			t.Errorf("expected error %v, got %v", expErr, err)
+		}
+	})
+	actAssembler = gzipstreamwriter.NewPositionalBlobAssembler(io.Discard, onMember)
+
+	for _, i := range []int{1, 2, 0} {
+		if err := actAssembler.Put(i, compressStdlib(t, []byte(fmt.Sprintf("blob number %d\n", i)))); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := actAssembler.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// Three blobs, and the member finished by Close.
+	if diff := cmp.Diff([]int{0, 1, 2, 3}, indexes); diff != "" {
+		t.Fatalf("TestPositionalBlobAssemblerOnMember() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBufferedBlobAssembler(t *testing.T) {
+	t.Parallel()
+
+	blobs := make([][]byte, 4)
+	for i := range blobs {
+		blobs[i] = compressStdlib(t, []byte(fmt.Sprintf("blob number %d\n", i)))
+	}
+	expected, err := gzipstreamwriter.AssembleGolden(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("new slice", func(t *testing.T) {
+		t.Parallel()
+
+		actAssembler := gzipstreamwriter.NewBufferedBlobAssembler(nil)
+		for _, blob := range blobs {
+			if err := actAssembler.AppendBlob(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if diff := cmp.Diff(expected, actAssembler.Bytes()); diff != "" {
+			t.Fatalf("TestBufferedBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+		// Later calls return the same stream.
+		if diff := cmp.Diff(expected, actAssembler.Bytes()); diff != "" {
+			t.Fatalf("TestBufferedBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+		if err := actAssembler.AppendBlob(blobs[0]); !errors.Is(err, gzipstreamwriter.ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("append to slice", func(t *testing.T) {
+		t.Parallel()
+
+		prefix := []byte("prefix")
+		actAssembler := gzipstreamwriter.NewBufferedBlobAssembler(prefix)
+		for _, blob := range blobs {
+			if err := actAssembler.AppendBlob(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if diff := cmp.Diff(append([]byte("prefix"), expected...), actAssembler.Bytes()); diff != "" {
+			t.Fatalf("TestBufferedBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		actAssembler := gzipstreamwriter.NewBufferedBlobAssembler(nil)
+		if err := actAssembler.AppendBlob([]byte("not a gzip blob at all")); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		// The invalid blob was rejected, and left the stream usable.
+		for _, blob := range blobs {
+			if err := actAssembler.AppendBlob(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if diff := cmp.Diff(expected, actAssembler.Bytes()); diff != "" {
+			t.Fatalf("TestBufferedBlobAssembler() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+// Assembling no blobs at all gives a single, valid, empty gzip member, the
+// same as the stdlib writes when closed without any data, rather than just
+// a header, or no output.
+func TestEmptyAssemblers(t *testing.T) {
+	t.Parallel()
+
+	expected := compressStdlib(t, nil)
+
+	testcases := []struct {
+		note     string
+		assemble func(t *testing.T) []byte
+	}{
+		{
+			note: "PositionalBlobAssembler",
+			assemble: func(t *testing.T) []byte {
+				t.Helper()
+				var buf bytes.Buffer
+				if err := gzipstreamwriter.NewPositionalBlobAssembler(&buf).Close(); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return buf.Bytes()
+			},
+		},
+		{
+			note: "BufferedBlobAssembler",
+			assemble: func(t *testing.T) []byte {
+				t.Helper()
+				return gzipstreamwriter.NewBufferedBlobAssembler(nil).Bytes()
+			},
+		},
+		{
+			note: "AssembleGolden",
+			assemble: func(t *testing.T) []byte {
+				t.Helper()
+				out, err := gzipstreamwriter.AssembleGolden(nil)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return out
+			},
+		},
+		{
+			note: "CompressBlobs",
+			assemble: func(t *testing.T) []byte {
+				t.Helper()
+				out, err := gzipstreamwriter.CompressBlobs(nil, gzipstreamwriter.DefaultCompression)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return out
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actual := tc.assemble(t)
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestEmptyAssemblers() mismatch (-want +got):\n%s", diff)
+			}
+			count, err := gzipstreamwriter.CountMembers(actual)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if count != 1 {
+				t.Fatalf("expected 1 member, got %d", count)
+			}
+			data, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actual))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(data) != 0 {
+				t.Fatalf("expected no data, got %d bytes", len(data))
+			}
+		})
+	}
+}
+
+func TestAppendBlobs(t *testing.T) {
+	t.Parallel()
+
+	var blobs [][]byte
+	for i := range 8 {
+		blobs = append(blobs, compressStdlib(t, randomTestBytes(1000*i)))
+	}
+	expected, err := gzipstreamwriter.AssembleGolden(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("appends to dst", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := gzipstreamwriter.AppendBlobs([]byte("prefix"), blobs)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(append([]byte("prefix"), expected...), actual); diff != "" {
+			t.Fatalf("TestAppendBlobs() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("reuses a scratch buffer", func(t *testing.T) {
+		t.Parallel()
+
+		// A scratch buffer with exactly enough room is never regrown.
+		scratch := make([]byte, 0, len(expected))
+		var actual []byte
+		for range 3 {
+			var err error
+			actual, err = gzipstreamwriter.AppendBlobs(scratch[:0], blobs)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if &actual[0] != &scratch[:1][0] {
+				t.Fatal("expected the scratch buffer to be reused")
+			}
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("TestAppendBlobs() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("no blobs", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := gzipstreamwriter.AppendBlobs(nil, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		data, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actual))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(data) != 0 {
+			t.Fatalf("expected no data, got %d bytes", len(data))
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		dst := []byte("prefix")
+		actual, err := gzipstreamwriter.AppendBlobs(dst, [][]byte{blobs[0], []byte("not a gzip blob at all")})
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		if diff := cmp.Diff(dst, actual); diff != "" {
+			t.Fatalf("TestAppendBlobs() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"io"
+)
+
+// asyncQueueSize is the number of submitted blobs an AsyncGzipStreamWriter
+// will hold before Submit blocks.
+const asyncQueueSize = 16
+
+// AsyncGzipStreamWriter writes compressed gzip blobs to a GzipStreamWriter
+// from a background goroutine. Blobs are submitted from any number of
+// goroutines with Submit, and are written in the order they were received.
+// A bounded queue between the two sides provides backpressure: Submit blocks
+// while the queue is full.
+//
+// If a blob fails to write (for example, because it is not a valid gzip blob),
+// the error is delivered on the Errors channel, and the writer halts: no more
+// blobs are written, and no trailer is written.
+type AsyncGzipStreamWriter struct {
+	z       *GzipStreamWriter
+	blobs   chan []byte
+	errs    chan error
+	stopped chan struct{}
+	err     error // Only read after stopped is closed.
+}
+
+// NewAsyncGzipStreamWriter creates a new AsyncGzipStreamWriter that writes a
+// single gzip stream at the default compression level to w, and starts its
+// background goroutine. Call Done to finish the stream and stop the goroutine.
+func NewAsyncGzipStreamWriter(w io.Writer) *AsyncGzipStreamWriter {
+	a := &AsyncGzipStreamWriter{
+		z:       NewGzipStreamWriter(w),
+		blobs:   make(chan []byte, asyncQueueSize),
+		errs:    make(chan error, 1),
+		stopped: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncGzipStreamWriter) run() {
+	a.err = a.writeAll()
+	// Mark the writer as halted before delivering the error, so that a
+	// Submit call made after receiving it always sees the halt.
+	close(a.stopped)
+	if a.err != nil {
+		a.errs <- a.err
+	}
+	close(a.errs)
+}
+
+// writeAll writes the queued blobs until the queue is closed by Done, and
+// then finishes the stream. It stops at the first error.
+func (a *AsyncGzipStreamWriter) writeAll() error {
+	for blob := range a.blobs {
+		if _, err := a.z.WriteCompressed(blob); err != nil {
+			return err
+		}
+	}
+	return a.z.Close()
+}
+
+// Submit queues a compressed gzip blob for writing, blocking while the queue
+// is full. The background goroutine reads the blob some time after Submit
+// returns, so the caller must leave it untouched from then on.
+// If the writer has halted, the blob is dropped, and the error that halted
+// the writer is returned. That includes a halt that happens while Submit is
+// queueing the blob. Blobs that were already queued when the writer halted
+// are dropped too, and are covered by the error from Errors and Done.
+//
+// Submit must not be called concurrently with, or after, Done.
+func (a *AsyncGzipStreamWriter) Submit(blob []byte) error {
+	select {
+	case <-a.stopped:
+		return a.err
+	default:
+	}
+	select {
+	case a.blobs <- blob:
+	case <-a.stopped:
+		return a.err
+	}
+	// The writer may have halted after the check above, in which case the
+	// blob went into a queue that nothing reads any more.
+	select {
+	case <-a.stopped:
+		return a.err
+	default:
+		return nil
+	}
+}
+
+// Errors returns a channel that receives the error that halted the writer, if
+// any. The channel is closed once the background goroutine exits.
+func (a *AsyncGzipStreamWriter) Errors() <-chan error {
+	return a.errs
+}
+
+// Done waits for all queued blobs to be written, writes the final trailer, and
+// stops the background goroutine. It returns the error that halted the writer,
+// if any. It does not close the underlying writer.
+func (a *AsyncGzipStreamWriter) Done() error {
+	close(a.blobs)
+	<-a.stopped
+	return a.err
+}
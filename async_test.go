@@ -0,0 +1,102 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestAsyncGzipStreamWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("same as synchronous writer", func(t *testing.T) {
+		t.Parallel()
+
+		blobs := make([][]byte, 100)
+		for i := range blobs {
+			blobs[i] = compressStdlib(t, []byte(fmt.Sprintf("event %d\n", i)))
+		}
+
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		for _, blob := range blobs {
+			if _, err := expGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := expGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewAsyncGzipStreamWriter(&actBuffer)
+		for _, blob := range blobs {
+			if err := actGzipWriter.Submit(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Done(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestAsyncGzipStreamWriter() mismatch (-want +got):\n%s", diff)
+		}
+		if err, ok := <-actGzipWriter.Errors(); ok {
+			t.Fatalf("expected closed errors channel, got %v", err)
+		}
+	})
+
+	t.Run("concurrent producers", func(t *testing.T) {
+		t.Parallel()
+
+		blob := compressStdlib(t, []byte("event\n"))
+		actGzipWriter := gzipstreamwriter.NewAsyncGzipStreamWriter(&bytes.Buffer{})
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					if err := actGzipWriter.Submit(blob); err != nil {
+						t.Errorf("expected no error, got %v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if err := actGzipWriter.Done(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("invalid blob halts writer", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewAsyncGzipStreamWriter(&bytes.Buffer{})
+		if err := actGzipWriter.Submit([]byte("not a gzip blob at all")); err != nil {
+			t.Fatalf("expected no error on submit, got %v", err)
+		}
+
+		if err := <-actGzipWriter.Errors(); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		// Every blob submitted after the halt is reported as dropped, even
+		// while the queue has room for it.
+		blob := compressStdlib(t, []byte("A"))
+		for range 100 {
+			if err := actGzipWriter.Submit(blob); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+				t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+			}
+		}
+		if err := actGzipWriter.Done(); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
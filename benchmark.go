@@ -0,0 +1,127 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// BenchmarkScenario measures the two ways of combining a set of gzip blobs
+// into a single gzip stream, on the caller's own data: splicing them with
+// WriteCompressed, and the naive way, which decompresses every blob, and
+// recompresses the data with a stdlib gzip.Writer. This shows what the
+// package saves for a given payload shape, outside of a test binary.
+type BenchmarkScenario struct {
+	blobs [][]byte
+	level int
+}
+
+// BenchmarkResult holds the measurements of one strategy, per iteration.
+type BenchmarkResult struct {
+	Duration    time.Duration // Time taken.
+	Allocs      uint64        // Number of heap allocations.
+	AllocBytes  uint64        // Bytes allocated on the heap.
+	OutputBytes int           // Size of the combined stream.
+}
+
+// BenchmarkReport holds the results of a BenchmarkScenario run.
+type BenchmarkReport struct {
+	Concatenate BenchmarkResult // Blobs spliced with WriteCompressed.
+	Recompress  BenchmarkResult // Blobs decompressed and recompressed.
+}
+
+// String returns a short, human-readable comparison of the two strategies.
+func (r BenchmarkReport) String() string {
+	return fmt.Sprintf("concatenate: %v, %d allocs, %d bytes allocated, %d bytes out\n"+
+		"recompress:  %v, %d allocs, %d bytes allocated, %d bytes out",
+		r.Concatenate.Duration, r.Concatenate.Allocs, r.Concatenate.AllocBytes, r.Concatenate.OutputBytes,
+		r.Recompress.Duration, r.Recompress.Allocs, r.Recompress.AllocBytes, r.Recompress.OutputBytes)
+}
+
+// NewBenchmarkScenario creates a new BenchmarkScenario for blobs, where both
+// strategies write their output at the specified compression level. The
+// level is checked here, so that Run cannot fail part way through on it; an
+// invalid one is reported as ErrInvalidCompressionLevel.
+func NewBenchmarkScenario(blobs [][]byte, level int) (*BenchmarkScenario, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, level)
+	}
+	return &BenchmarkScenario{blobs: blobs, level: level}, nil
+}
+
+// Run runs each strategy iterations times, one after the other, and reports
+// their averages. Values below 1 mean a single iteration. An invalid blob
+// returns an error wrapping ErrBlob, naming the blob's index.
+func (s *BenchmarkScenario) Run(iterations int) (BenchmarkReport, error) {
+	iterations = max(iterations, 1)
+	var report BenchmarkReport
+	var err error
+	if report.Concatenate, err = measure(iterations, s.concatenate); err != nil {
+		return BenchmarkReport{}, err
+	}
+	if report.Recompress, err = measure(iterations, s.recompress); err != nil {
+		return BenchmarkReport{}, err
+	}
+	return report, nil
+}
+
+// concatenate combines the blobs with WriteCompressed, and returns the size
+// of the output.
+func (s *BenchmarkScenario) concatenate() (int, error) {
+	out, err := CompressBlobs(s.blobs, s.level)
+	return len(out), err
+}
+
+// recompress combines the blobs by decompressing them, and compressing their
+// data again, and returns the size of the output.
+func (s *BenchmarkScenario) recompress() (int, error) {
+	var buf bytes.Buffer
+	// The level was validated by NewBenchmarkScenario, so this cannot fail.
+	gzWriter, _ := gzip.NewWriterLevel(&buf, s.level)
+	var gzReader gzip.Reader
+	for i, blob := range s.blobs {
+		if err := gzReader.Reset(bytes.NewReader(blob)); err != nil {
+			return 0, fmt.Errorf("blob %d: %w: %w", i, ErrBlob, err)
+		}
+		if _, err := io.Copy(gzWriter, &gzReader); err != nil {
+			return 0, fmt.Errorf("blob %d: %w: %w", i, ErrBlob, err)
+		}
+	}
+	if err := gzWriter.Close(); err != nil {
+		return 0, fmt.Errorf("gzip: failed to compress: %w", err)
+	}
+	return buf.Len(), nil
+}
+
+// measure runs fn iterations times, and returns its average time and heap
+// allocations, and the output size from its last run.
+func measure(iterations int, fn func() (int, error)) (BenchmarkResult, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var size int
+	for range iterations {
+		var err error
+		if size, err = fn(); err != nil {
+			return BenchmarkResult{}, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	n := uint64(iterations)
+	return BenchmarkResult{
+		Duration:    elapsed / time.Duration(iterations),
+		Allocs:      (after.Mallocs - before.Mallocs) / n,
+		AllocBytes:  (after.TotalAlloc - before.TotalAlloc) / n,
+		OutputBytes: size,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestBenchmarkScenario(t *testing.T) {
+	t.Parallel()
+
+	blobs := make([][]byte, 8)
+	for i := range blobs {
+		blobs[i] = compressStdlib(t, randomTestBytes(10_000+i))
+	}
+	expected, err := gzipstreamwriter.CompressBlobs(blobs, gzipstreamwriter.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("run", func(t *testing.T) {
+		t.Parallel()
+
+		scenario, err := gzipstreamwriter.NewBenchmarkScenario(blobs, gzipstreamwriter.DefaultCompression)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		report, err := scenario.Run(3)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if report.Concatenate.OutputBytes != len(expected) {
+			t.Fatalf("expected %d output bytes, got %d", len(expected), report.Concatenate.OutputBytes)
+		}
+		if report.Recompress.OutputBytes == 0 {
+			t.Fatal("expected recompressed output, got 0 bytes")
+		}
+		if report.Concatenate.Duration <= 0 || report.Recompress.Duration <= 0 {
+			t.Fatalf("expected positive durations, got %v and %v", report.Concatenate.Duration, report.Recompress.Duration)
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewBenchmarkScenario(blobs, gzipstreamwriter.BestCompression+1); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected ErrInvalidCompressionLevel, got %v", err)
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		scenario, err := gzipstreamwriter.NewBenchmarkScenario([][]byte{blobs[0], []byte("not a gzip blob at all")}, gzipstreamwriter.DefaultCompression)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := scenario.Run(1); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
+
+// This compares splicing blobs with WriteCompressed against decompressing
+// and recompressing them. The timings depend on the machine, so there is no
+// fixed output.
+func ExampleBenchmarkScenario() {
+	var blobs [][]byte
+	for i := range 100 {
+		var buf bytes.Buffer
+		gzWriter := gzip.NewWriter(&buf)
+		fmt.Fprintf(gzWriter, "event %d: something happened\n", i)
+		if err := gzWriter.Close(); err != nil {
+			log.Fatal(err)
+		}
+		blobs = append(blobs, buf.Bytes())
+	}
+
+	scenario, err := gzipstreamwriter.NewBenchmarkScenario(blobs, gzipstreamwriter.DefaultCompression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := scenario.Run(10)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report)
+}
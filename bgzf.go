@@ -0,0 +1,153 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// bgzfMaxBlockInput is the most uncompressed data put in a single BGZF
+	// block. This matches the reference bgzip implementation, and leaves room
+	// for the block to stay under bgzfMaxBlockSize even if the data does not
+	// compress at all.
+	bgzfMaxBlockInput = 0xff00
+	// bgzfMaxBlockSize is the largest a complete BGZF block may be.
+	bgzfMaxBlockSize = 0x10000
+	// bgzfBlockSizeOffset is the offset of the BSIZE value in a BGZF block
+	// header: 10 fixed header bytes, 2 bytes of XLEN, and 4 bytes of subfield header.
+	bgzfBlockSizeOffset = 16
+)
+
+// bgzfEOFMarker is the empty block that terminates a BGZF file.
+var bgzfEOFMarker = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// BGZFWriter writes BGZF (Blocked GNU Zip Format) output, as used for .bgz
+// files in bioinformatics tools like samtools and tabix.
+//
+// BGZF is a multi-member gzip stream, where each member ("block") is at most
+// 64 KB compressed, and carries a 'BC' Extra subfield holding its total size.
+// This allows readers to seek to block boundaries. Close terminates the
+// output with the standard empty EOF marker block.
+// The output is valid gzip, and can be read by any multistream gzip reader.
+type BGZFWriter struct {
+	w       io.Writer
+	z       *GzipStreamWriter // Compresses each block into buf.
+	buf     bytes.Buffer
+	pending []byte // Uncompressed data for the next block.
+	err     error
+	closed  bool
+}
+
+// NewBGZFWriter creates a new BGZFWriter that compresses at the specified level.
+func NewBGZFWriter(w io.Writer, level int) (*BGZFWriter, error) {
+	z, err := NewGzipStreamWriterLevel(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	return &BGZFWriter{
+		w:       w,
+		z:       z,
+		pending: make([]byte, 0, bgzfMaxBlockInput),
+	}, nil
+}
+
+// Write buffers p, and writes out a block each time a full block's worth of
+// data is available.
+func (b *BGZFWriter) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), bgzfMaxBlockInput-len(b.pending))
+		b.pending = append(b.pending, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(b.pending) == bgzfMaxBlockInput {
+			if err := b.writePending(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes any buffered data out as a (possibly short) block.
+func (b *BGZFWriter) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+	return b.writePending()
+}
+
+// Close flushes any buffered data, and writes the BGZF EOF marker block.
+// It does not close the underlying writer.
+func (b *BGZFWriter) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	_, b.err = b.w.Write(bgzfEOFMarker)
+	return b.err
+}
+
+func (b *BGZFWriter) writePending() error {
+	if b.err = b.writeBlock(b.pending); b.err != nil {
+		return b.err
+	}
+	b.pending = b.pending[:0]
+	return nil
+}
+
+// writeBlock compresses p into one or more BGZF blocks, and writes them out.
+func (b *BGZFWriter) writeBlock(p []byte) error {
+	b.buf.Reset()
+	b.z.Reset(&b.buf)
+	// BSIZE is patched in once the compressed size is known.
+	if err := b.z.AddExtraSubfield('B', 'C', []byte{0, 0}); err != nil {
+		return err
+	}
+	if _, err := b.z.Write(p); err != nil {
+		return err
+	}
+	if err := b.z.Close(); err != nil {
+		return err
+	}
+
+	// Data that compresses very poorly can overflow the block size limit.
+	// Split it up, and try again.
+	if b.buf.Len() > bgzfMaxBlockSize {
+		half := len(p) / 2
+		if err := b.writeBlock(p[:half]); err != nil {
+			return err
+		}
+		return b.writeBlock(p[half:])
+	}
+
+	block := b.buf.Bytes()
+	binary.LittleEndian.PutUint16(block[bgzfBlockSizeOffset:], uint16(len(block)-1))
+	if _, err := b.w.Write(block); err != nil {
+		return fmt.Errorf("gzip: failed to write BGZF block: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestBGZFWriter(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note  string
+		level int
+		input []byte
+	}{
+		{
+			note:  "empty input",
+			level: gzipstreamwriter.DefaultCompression,
+			input: nil,
+		},
+		{
+			note:  "short input",
+			level: gzipstreamwriter.DefaultCompression,
+			input: []byte("chr1\t100\t200\n"),
+		},
+		{
+			note:  "several blocks",
+			level: gzipstreamwriter.BestSpeed,
+			input: bytes.Repeat([]byte("chr1\t100\t200\tACGTACGTTGCA\n"), 20000),
+		},
+		{
+			note:  "incompressible data",
+			level: gzipstreamwriter.NoCompression,
+			input: randomTestBytes(200000),
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actBGZFWriter, err := gzipstreamwriter.NewBGZFWriter(&actBuffer, tc.level)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// Write in odd-sized pieces, to exercise block boundaries.
+			for input := tc.input; len(input) > 0; {
+				n := min(len(input), 12345)
+				if _, err := actBGZFWriter.Write(input[:n]); err != nil {
+					t.Fatal(err)
+				}
+				input = input[n:]
+			}
+			if err := actBGZFWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			result := readBGZF(t, actBuffer.Bytes())
+			if !bytes.Equal(tc.input, result) {
+				t.Fatalf("expected %d bytes of round-tripped data, got %d bytes", len(tc.input), len(result))
+			}
+
+			// Any multistream gzip reader must be able to read BGZF too.
+			result, err = gzipstreamwriter.DecompressAll(bytes.NewReader(actBuffer.Bytes()))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(tc.input, result) {
+				t.Fatalf("expected %d bytes of decompressed data, got %d bytes", len(tc.input), len(result))
+			}
+		})
+	}
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewBGZFWriter(io.Discard, 11); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrInvalidCompressionLevel, err)
+		}
+	})
+}
+
+// readBGZF reads a BGZF file the way a BGZF reader does: by jumping from block
+// to block using each block's BSIZE, and checking the layout of each block
+// against the spec along the way.
+func readBGZF(t *testing.T, p []byte) []byte {
+	t.Helper()
+
+	expEOFMarker := []byte{
+		0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+		0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.HasSuffix(p, expEOFMarker) {
+		t.Fatal("expected BGZF EOF marker at end of output")
+	}
+
+	var result []byte
+	for len(p) > 0 {
+		if len(p) < 18 {
+			t.Fatalf("expected a BGZF block, got %d trailing bytes", len(p))
+		}
+		if diff := cmp.Diff([]byte{0x1f, 0x8b, 8, 4}, p[:4]); diff != "" {
+			t.Fatalf("readBGZF() block header mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]byte{6, 0, 'B', 'C', 2, 0}, p[10:16]); diff != "" {
+			t.Fatalf("readBGZF() extra field mismatch (-want +got):\n%s", diff)
+		}
+		blockSize := int(binary.LittleEndian.Uint16(p[16:18])) + 1
+		if blockSize > len(p) {
+			t.Fatalf("expected block size %d to fit in remaining %d bytes", blockSize, len(p))
+		}
+		block, err := gzipstreamwriter.DecompressAll(bytes.NewReader(p[:blockSize]))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		result = append(result, block...)
+		p = p[blockSize:]
+	}
+	return result
+}
+
+func randomTestBytes(n int) []byte {
+	b := make([]byte, n)
+	state := uint32(2463534242)
+	for i := range b {
+		// xorshift32
+		state ^= state << 13
+		state ^= state >> 17
+		state ^= state << 5
+		b[i] = byte(state)
+	}
+	return b
+}
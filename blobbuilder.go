@@ -0,0 +1,68 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// BlobBuilder compresses payloads into minimal, single-member gzip blobs,
+// suitable for passing to WriteCompressed.
+//
+// Since WriteCompressed strips each blob's header anyway, the blobs carry the
+// smallest possible header: no Name, Comment, or Extra fields, and a zero
+// ModTime. The flate.Writer instances used for compression are pooled, so a
+// single BlobBuilder can be shared between goroutines.
+type BlobBuilder struct {
+	level int
+	pool  sync.Pool
+}
+
+// NewBlobBuilder creates a new BlobBuilder that compresses at the specified level.
+func NewBlobBuilder(level int) (*BlobBuilder, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, level)
+	}
+	b := &BlobBuilder{level: level}
+	b.pool.New = func() any {
+		// The level was validated above, so this cannot fail.
+		compressor, _ := flate.NewWriter(nil, level)
+		return compressor
+	}
+	return b, nil
+}
+
+// Build compresses p into a new single-member gzip blob.
+func (b *BlobBuilder) Build(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(10 + len(p)/2 + 8) // Rough guess, to avoid the first few reallocations.
+
+	header := [10]byte{gzipID1, gzipID2, gzipDeflate, 0, 0, 0, 0, 0, xflForLevel(b.level), 255}
+	buf.Write(header[:])
+
+	compressor, ok := b.pool.Get().(*flate.Writer)
+	if !ok {
+		compressor, _ = flate.NewWriter(nil, b.level)
+	}
+	defer b.pool.Put(compressor)
+	compressor.Reset(&buf)
+	if _, err := compressor.Write(p); err != nil {
+		return nil, fmt.Errorf("gzip: failed to compress blob: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("gzip: failed to compress blob: %w", err)
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], crc32.ChecksumIEEE(p))
+	binary.LittleEndian.PutUint32(trailer[4:8], uint32(len(p)))
+	buf.Write(trailer[:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestBlobBuilder(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note  string
+		level int
+		input []byte
+	}{
+		{
+			note:  "nil input",
+			level: gzipstreamwriter.DefaultCompression,
+			input: nil,
+		},
+		{
+			note:  "single byte",
+			level: gzipstreamwriter.BestSpeed,
+			input: []byte("A"),
+		},
+		{
+			note:  "many repeated bytes",
+			level: gzipstreamwriter.BestCompression,
+			input: bytes.Repeat([]byte("A"), 1000),
+		},
+	}
+
+	builder, err := gzipstreamwriter.NewBlobBuilder(gzipstreamwriter.DefaultCompression)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			builder, err := gzipstreamwriter.NewBlobBuilder(tc.level)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			blob, err := builder.Build(tc.input)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// With no header metadata set, this should match stdlib byte-for-byte.
+			expBuffer := bytes.Buffer{}
+			expGzipWriter, _ := gzip.NewWriterLevel(&expBuffer, tc.level)
+			if _, err := writeToBuffer(t, expGzipWriter, tc.input); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expBuffer.Bytes(), blob); diff != "" {
+				t.Fatalf("TestBlobBuilder() mismatch (-want +got):\n%s", diff)
+			}
+
+			if _, err := gzipstreamwriter.NewGzipStreamWriter(io.Discard).WriteCompressed(blob); err != nil {
+				t.Fatalf("expected WriteCompressed to accept blob, got %v", err)
+			}
+		})
+	}
+
+	t.Run("shared builder", func(t *testing.T) {
+		t.Parallel()
+
+		for i := range 10 {
+			input := bytes.Repeat([]byte{byte(i)}, i*100)
+			blob, err := builder.Build(input)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(blob))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(input, result) {
+				t.Fatalf("expected %v, got %v", input, result)
+			}
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewBlobBuilder(10); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrInvalidCompressionLevel, err)
+		}
+	})
+}
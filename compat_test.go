@@ -0,0 +1,140 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	kgzip "github.com/klauspost/compress/gzip"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+// The klauspost blobs in testdata/blobs were written by
+// github.com/klauspost/compress/gzip. Unlike the stdlib, klauspost always
+// writes the MTIME field, and uses different block layouts at some levels.
+// The assembled output is read back with the stdlib gzip reader, the
+// klauspost gzip reader, and NewVerifyingReader.
+//
+// klauspost/compress is only a test dependency, pinned to the newest version
+// that builds with the Go version in go.mod.
+func TestKlauspostCompatibility(t *testing.T) {
+	t.Parallel()
+
+	names, err := filepath.Glob(filepath.Join("testdata", "blobs", "klauspost-*.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("expected klauspost blobs in testdata/blobs")
+	}
+
+	var blobs [][]byte
+	var inputs [][]byte
+	for _, name := range names {
+		blob, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzReader, err := gzip.NewReader(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		data, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+
+		// The header parses the same as with the stdlib.
+		header, err := gzipstreamwriter.ParseBlobHeader(blob)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if diff := cmp.Diff(gzReader.Header, header.Header); diff != "" {
+			t.Fatalf("%s: TestKlauspostCompatibility() mismatch (-want +got):\n%s", name, diff)
+		}
+		content, _, isize, err := gzipstreamwriter.TrimBlob(blob)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if header.Length+len(content)+8 != len(blob) {
+			t.Fatalf("%s: expected header length %d, got %d", name, len(blob)-len(content)-8, header.Length)
+		}
+		if int(isize) != len(data) {
+			t.Fatalf("%s: expected ISIZE %d, got %d", name, len(data), isize)
+		}
+
+		blobs = append(blobs, blob)
+		inputs = append(inputs, data)
+	}
+
+	testcases := []struct {
+		note string
+		opts []gzipstreamwriter.Option
+		// Interleave each blob with raw writes.
+		interleave bool
+	}{
+		{note: "blobs only"},
+		{note: "blobs and writes", interleave: true},
+		{note: "verified blobs", opts: []gzipstreamwriter.Option{gzipstreamwriter.VerifyBlobs(true)}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			var expected []byte
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, tc.opts...)
+			for i, blob := range blobs {
+				if tc.interleave {
+					chunk := randomTestBytes(1000 + i)
+					if _, err := actGzipWriter.Write(chunk); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+					expected = append(expected, chunk...)
+				}
+				if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+					t.Fatalf("%s: expected no error, got %v", names[i], err)
+				}
+				expected = append(expected, inputs[i]...)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			gzReader, err := gzip.NewReader(bytes.NewReader(actBuffer.Bytes()))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestKlauspostCompatibility() mismatch (-want +got):\n%s", diff)
+			}
+			kpReader, err := kgzip.NewReader(bytes.NewReader(actBuffer.Bytes()))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			kpActual, err := io.ReadAll(kpReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, kpActual); diff != "" {
+				t.Fatalf("TestKlauspostCompatibility() mismatch (-want +got):\n%s", diff)
+			}
+			verified, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(actBuffer.Bytes())))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, verified); diff != "" {
+				t.Fatalf("TestKlauspostCompatibility() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Compress compresses p into a standalone, single-member gzip blob at the
+// given compression level, with an empty header.
+// An invalid level returns an error wrapping ErrInvalidCompressionLevel.
+func Compress(p []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	z, err := NewGzipStreamWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := z.Write(p); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressBlobs concatenates the pre-compressed gzip blobs into a single gzip
+// stream, by passing each blob to WriteCompressed in order. The blobs are not
+// recompressed; level only sets the header's XFL byte, like it does for
+// NewGzipStreamWriterLevel. An invalid level returns an error wrapping
+// ErrInvalidCompressionLevel, and an invalid blob returns an error wrapping
+// ErrBlob, naming the blob's index.
+func CompressBlobs(blobs [][]byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	z, err := NewGzipStreamWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	for i, blob := range blobs {
+		if _, err := z.WriteCompressed(blob); err != nil {
+			return nil, fmt.Errorf("blob %d: %w", i, err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
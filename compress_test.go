@@ -0,0 +1,99 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(100_000)
+
+	for _, level := range []int{gzipstreamwriter.HuffmanOnly, gzipstreamwriter.NoCompression, gzipstreamwriter.BestSpeed, gzipstreamwriter.DefaultCompression, gzipstreamwriter.BestCompression} {
+		t.Run(fmt.Sprintf("level %d", level), func(t *testing.T) {
+			t.Parallel()
+
+			blob, err := gzipstreamwriter.Compress(input, level)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			gzReader, err := gzip.NewReader(bytes.NewReader(blob))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(input, actual); diff != "" {
+				t.Fatalf("TestCompress() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.Compress(input, 10); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected ErrInvalidCompressionLevel, got %v", err)
+		}
+	})
+}
+
+func TestCompressBlobs(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{randomTestBytes(10_000), []byte("hello, world!\n"), {}, randomTestBytes(50_000)}
+	var blobs [][]byte
+	var expected []byte
+	for _, input := range inputs {
+		blobs = append(blobs, compressStdlib(t, input))
+		expected = append(expected, input...)
+	}
+
+	actual, err := gzipstreamwriter.CompressBlobs(blobs, gzipstreamwriter.BestSpeed)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	golden, err := gzipstreamwriter.AssembleGolden(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the XFL byte differs from the default level.
+	golden[8] = 4
+	if diff := cmp.Diff(golden, actual); diff != "" {
+		t.Fatalf("TestCompressBlobs() mismatch (-want +got):\n%s", diff)
+	}
+	decompressed, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actual))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if diff := cmp.Diff(expected, decompressed); diff != "" {
+		t.Fatalf("TestCompressBlobs() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.CompressBlobs(blobs, -3); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected ErrInvalidCompressionLevel, got %v", err)
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.CompressBlobs([][]byte{blobs[0], []byte("not a gzip blob")}, gzipstreamwriter.DefaultCompression)
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WriteCompressedReader reads a single compressed gzip blob of exactly size
+// bytes from r, and writes it through to the underlying writer like
+// WriteCompressed does, without holding the whole blob in memory.
+//
+// The minimum buffering required is the blob's header (10 bytes, plus any
+// Extra, Name, Comment, and header CRC fields) and its 8-byte trailer. The
+// DEFLATE payload in between is copied from r to the underlying writer in
+// chunks as it arrives. Reads from r go through a small internal buffer, but
+// never past the end of the blob.
+//
+// Since the trailer is only read after the payload was written, a blob whose
+// payload or trailer turns out to be short leaves partial output behind, and
+// the writer is left in a terminal error state. Errors in the header are
+// caught before anything is written.
+//
+// If blob verification is enabled with VerifyBlobs, the whole blob has to be
+// buffered, and is passed to WriteCompressed instead.
+func (z *GzipStreamWriter) WriteCompressedReader(r io.Reader, size int) error {
+	if z.err != nil {
+		return z.err
+	}
+	if err := z.checkMemberLimit(); err != nil {
+		return err
+	}
+
+	// Not a compliant Gzip blob. We can reject this up front.
+	if size < 18 {
+		return ErrBlob
+	}
+	br := bufio.NewReader(io.LimitReader(r, int64(size)))
+
+	if z.options.verifyBlobs {
+		p := make([]byte, size)
+		if _, err := io.ReadFull(br, p); err != nil {
+			return fmt.Errorf("%w: %w", ErrBlob, err)
+		}
+		_, err := z.WriteCompressed(p)
+		return err
+	}
+
+	header, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	if z.options.strictHeaders {
+		if err := checkReservedFlags(header); err != nil {
+			return err
+		}
+	}
+	if err := z.checkMixedLevels(header); err != nil {
+		return err
+	}
+	contentLength := size - len(header) - 8
+	if contentLength < 0 {
+		return fmt.Errorf("%w: header overruns trailer", ErrBlob)
+	}
+	// Splicing adds at most a few bytes to the payload.
+	if err := z.checkOutputLimit(contentLength + spliceTailSize - 1); err != nil {
+		return err
+	}
+
+	start := z.w.n
+	if !z.checkWroteHeader() {
+		if _, z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+	}
+
+	// Flush the current deflate stream, if one was active.
+	if z.checkActiveDeflateStream() {
+		if z.err = z.flushCompressor(); z.err != nil {
+			return z.err
+		}
+		z.setActiveDeflateStream(false)
+	}
+	z.clearHistory()
+
+	// Splice the payload on its way through, like WriteCompressed does.
+	scan, err := spliceDeflateStream(sinkWriter{z}, io.LimitReader(br, int64(contentLength)))
+	if err != nil {
+		z.err = err
+		return z.err
+	}
+	if scan.length != contentLength {
+		z.err = fmt.Errorf("%w: trailing data after deflate stream", ErrBlob)
+		return z.err
+	}
+	var trailer [8]byte
+	if _, z.err = io.ReadFull(br, trailer[:]); z.err != nil {
+		return z.err
+	}
+	trailerChecksum := binary.LittleEndian.Uint32(trailer[:4])
+	trailerLength := binary.LittleEndian.Uint32(trailer[4:])
+
+	z.size += uint64(trailerLength)
+	z.digest = crc32Combine(z.crcTable, z.digest, trailerChecksum, int(trailerLength))
+	z.members++
+	z.memberEvent(int(z.w.n-start), trailerChecksum)
+	return nil
+}
+
+// WriteCompressedFrom writes the single compressed gzip blob that spans from
+// the current offset of r to its end, like WriteCompressedReader does. The
+// size of the blob is found by seeking to the end of r, so r must be
+// seekable, such as an [os.File] holding a blob too large to buffer. After
+// seeking back, the DEFLATE payload is streamed to the underlying writer in
+// chunks, and the running CRC32 is updated from the blob's trailer, without
+// decompressing anything.
+//
+// It returns the number of bytes written to the underlying writer, which
+// includes the header, if it had not been written yet.
+func (z *GzipStreamWriter) WriteCompressedFrom(r io.ReadSeeker) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("gzip: failed to seek blob: %w", err)
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("gzip: failed to seek blob: %w", err)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("gzip: failed to seek blob: %w", err)
+	}
+
+	before := z.w.n
+	err = z.WriteCompressedReader(r, int(end-start))
+	return int(z.w.n - before), err
+}
+
+// readHeader reads a complete gzip header from br, and returns its raw bytes.
+func readHeader(br *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 10, 64)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, headerReadError(header, err)
+	}
+
+	flag := header[3]
+	if flag&flagExtra != 0 {
+		var lengthPrefix [2]byte
+		if _, err := io.ReadFull(br, lengthPrefix[:]); err != nil {
+			return nil, headerReadError(header, err)
+		}
+		header = append(header, lengthPrefix[:]...)
+		extra := make([]byte, binary.LittleEndian.Uint16(lengthPrefix[:]))
+		if _, err := io.ReadFull(br, extra); err != nil {
+			return nil, headerReadError(header, err)
+		}
+		header = append(header, extra...)
+	}
+	for _, fieldFlag := range []byte{flagName, flagComment} {
+		if flag&fieldFlag != 0 {
+			field, err := br.ReadBytes(0)
+			if err != nil {
+				return nil, headerReadError(header, err)
+			}
+			header = append(header, field...)
+		}
+	}
+	if flag&flagHdrCrc != 0 {
+		var headerCRC [2]byte
+		if _, err := io.ReadFull(br, headerCRC[:]); err != nil {
+			return nil, headerReadError(header, err)
+		}
+		header = append(header, headerCRC[:]...)
+	}
+
+	// Validate the magic bytes, and double-check our field walk.
+	headerLength, err := getHeaderLength(header)
+	if err != nil {
+		return nil, err
+	}
+	if headerLength != len(header) {
+		return nil, fmt.Errorf("%w: header length mismatch", ErrBlob)
+	}
+	return header, nil
+}
+
+// headerReadError converts an error from reading a header into the package's
+// error types. Running out of bytes means a truncated header, unless the bytes
+// so far were not a gzip header to begin with.
+func headerReadError(header []byte, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if _, headerErr := getHeaderLength(header); headerErr != nil && !errors.Is(headerErr, ErrTruncatedHeader) {
+			return headerErr
+		}
+		return errTruncatedHeader("header")
+	}
+	return fmt.Errorf("gzip: failed to read header: %w", err)
+}
@@ -0,0 +1,199 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestWriteCompressedReader(t *testing.T) {
+	t.Parallel()
+
+	headers := []struct {
+		note   string
+		header gzip.Header
+	}{
+		{
+			note:   "bare header",
+			header: gzip.Header{},
+		},
+		{
+			note:   "all header fields",
+			header: gzip.Header{Extra: []byte{'A', 'B', 2, 0, 1, 2}, Name: "events.json", Comment: "a comment"},
+		},
+	}
+
+	for _, tc := range headers {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			var blobBuffer bytes.Buffer
+			w := gzip.NewWriter(&blobBuffer)
+			w.Header = tc.header
+			if _, err := writeToBuffer(t, w, bytes.Repeat([]byte("hello, world! "), 1000)); err != nil {
+				t.Fatal(err)
+			}
+			blob := blobBuffer.Bytes()
+
+			// Streaming a blob must be the same as writing it all at once.
+			expBuffer := bytes.Buffer{}
+			expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+			if _, err := expGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatal(err)
+			}
+			if err := expGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			// The trailing bytes belong to the next blob, and must not be consumed.
+			r := bytes.NewReader(append(bytes.Clone(blob), "next blob"...))
+			if err := actGzipWriter.WriteCompressedReader(iotest.OneByteReader(r), len(blob)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+				t.Fatalf("TestWriteCompressedReader() mismatch (-want +got):\n%s", diff)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "next blob" {
+				t.Fatalf("expected remaining bytes %q, got %q", "next blob", rest)
+			}
+		})
+	}
+}
+
+func TestWriteCompressedReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, []byte("hello, world!"))
+	namedBlob := func() []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Name = "events.json"
+		if _, err := writeToBuffer(t, w, []byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}()
+
+	testcases := []struct {
+		note      string
+		input     []byte
+		size      int
+		err       error
+		truncated bool
+		sticky    bool
+	}{
+		{
+			note:  "size too small",
+			input: blob,
+			size:  17,
+			err:   gzipstreamwriter.ErrBlob,
+		},
+		{
+			note:  "bad magic bytes",
+			input: append([]byte("xx"), blob[2:]...),
+			size:  len(blob),
+			err:   gzipstreamwriter.ErrBlob,
+		},
+		{
+			note:      "name runs past size",
+			input:     namedBlob,
+			size:      18,
+			err:       gzipstreamwriter.ErrBlob,
+			truncated: true,
+		},
+		{
+			note:   "reader shorter than size",
+			input:  blob[:len(blob)-4],
+			size:   len(blob),
+			err:    io.ErrUnexpectedEOF,
+			sticky: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+			err := actGzipWriter.WriteCompressedReader(bytes.NewReader(tc.input), tc.size)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if errors.Is(err, gzipstreamwriter.ErrTruncatedHeader) != tc.truncated {
+				t.Fatalf("expected truncated header: %v, got error %v", tc.truncated, err)
+			}
+			if sticky := actGzipWriter.Err() != nil; sticky != tc.sticky {
+				t.Fatalf("expected sticky error: %v, got %v", tc.sticky, actGzipWriter.Err())
+			}
+		})
+	}
+}
+
+func TestWriteCompressedFrom(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, randomTestBytes(100_000))
+
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	if _, err := expGzipWriter.Write([]byte("hello, world!\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := expGzipWriter.WriteCompressed(blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := expGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blob starts after some unrelated leading bytes in the file.
+	file := createTempFile(t)
+	if _, err := file.Write(append([]byte("leading bytes"), blob...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(int64(len("leading bytes")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	if _, err := actGzipWriter.Write([]byte("hello, world!\n")); err != nil {
+		t.Fatal(err)
+	}
+	before := actBuffer.Len()
+	n, err := actGzipWriter.WriteCompressedFrom(file)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != actBuffer.Len()-before {
+		t.Fatalf("expected %d bytes written, got %d", actBuffer.Len()-before, n)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+		t.Fatalf("TestWriteCompressedFrom() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("truncated blob", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.WriteCompressedFrom(bytes.NewReader(blob[:10])); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
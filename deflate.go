@@ -0,0 +1,516 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DEFLATE (RFC 1951) streams are not length-prefixed, so the only way to find
+// where one ends is to walk its blocks. The scanner below does exactly that:
+// it decodes block headers and Huffman symbols, but never materializes any
+// decompressed output, runs a checksum, or maintains a history window.
+// This is modeled on zlib's puff.c, which is small and easy to audit, at the
+// cost of decoding Huffman codes one bit at a time.
+
+const (
+	maxCodeBits   = 15  // Maximum bits in any Huffman code.
+	maxLitLenSyms = 288 // Number of literal/length symbols in the fixed code.
+	maxDistSyms   = 30  // Number of distance symbols.
+	numCodeLenSym = 19  // Number of code length code symbols.
+)
+
+// Extra bits for length symbols 257..285.
+var lengthExtraBits = [29]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0,
+}
+
+// Extra bits for distance symbols 0..29.
+var distExtraBits = [30]uint8{
+	0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+	7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13,
+}
+
+// Permutation of code length codes, from section 3.2.7.
+var codeLenOrder = [numCodeLenSym]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// deflateScan describes the block layout of a single DEFLATE stream.
+type deflateScan struct {
+	length        int  // Length of the stream in bytes, including the final block's padding bits.
+	finalBlockBit int  // Bit offset of the final block's header, which starts with the BFINAL bit.
+	finalBlockEnd int  // Bit offset just past the end of the final block, before any padding bits.
+	hasData       bool // Whether any block holds data, so that the stream decompresses to something.
+}
+
+// bitReader reads LSB-first bit fields from a byte slice.
+//
+// When src is set, p is instead a sliding window over the stream read from
+// src. Each time the window is refilled, the bytes already read past are
+// written to dst, and counted in base.
+type bitReader struct {
+	p   []byte
+	pos int // Current bit offset into p.
+
+	src  io.Reader
+	dst  io.Writer
+	base int // Bytes of the stream that were dropped from the front of p.
+}
+
+// offset returns the current bit offset into the whole stream.
+func (br *bitReader) offset() int {
+	return br.base*8 + br.pos
+}
+
+func (br *bitReader) readBits(n int) (uint32, error) {
+	if br.pos+n > len(br.p)*8 {
+		if err := br.refill(n); err != nil {
+			return 0, err
+		}
+	}
+	var v uint32
+	for i := range n {
+		bit := (br.p[br.pos>>3] >> (br.pos & 7)) & 1
+		v |= uint32(bit) << i
+		br.pos++
+	}
+	return v, nil
+}
+
+// alignToByte skips any remaining bits in the current byte.
+func (br *bitReader) alignToByte() {
+	br.pos = (br.pos + 7) &^ 7
+}
+
+// skipBytes skips n bytes. The reader must be byte-aligned.
+func (br *bitReader) skipBytes(n int) error {
+	for {
+		avail := len(br.p) - br.pos/8
+		if n <= avail {
+			br.pos += n * 8
+			return nil
+		}
+		br.pos += avail * 8
+		n -= avail
+		if err := br.refill(8); err != nil {
+			return err
+		}
+	}
+}
+
+// refill slides the window forward, until at least n bits are available.
+func (br *bitReader) refill(n int) error {
+	if br.src == nil {
+		return fmt.Errorf("%w: truncated deflate stream", ErrBlob)
+	}
+
+	if done := br.pos / 8; done > 0 {
+		if _, err := br.dst.Write(br.p[:done]); err != nil {
+			return fmt.Errorf("gzip: failed to write deflate data: %w", err)
+		}
+		br.base += done
+		br.pos -= done * 8
+		br.p = br.p[:copy(br.p, br.p[done:])]
+	}
+	for br.pos+n > len(br.p)*8 {
+		m, err := br.src.Read(br.p[len(br.p):cap(br.p)])
+		br.p = br.p[:len(br.p)+m]
+		if errors.Is(err, io.EOF) {
+			if br.pos+n > len(br.p)*8 {
+				return fmt.Errorf("%w: truncated deflate stream", ErrBlob)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("gzip: failed to read deflate data: %w", err)
+		}
+	}
+	return nil
+}
+
+// huffman is a canonical Huffman decoding table, in the style of puff.c.
+type huffman struct {
+	count  [maxCodeBits + 1]uint16 // Number of codes of each length.
+	symbol []uint16                // Symbols, ordered by code length, then by symbol value.
+}
+
+// newHuffman builds a decoding table from the code lengths for each symbol.
+// Over-subscribed code sets are rejected. Incomplete code sets are allowed,
+// and are caught during decoding if an unused code shows up in the stream.
+func newHuffman(lengths []uint8) (*huffman, error) {
+	h := &huffman{symbol: make([]uint16, 0, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return nil, fmt.Errorf("%w: over-subscribed huffman code", ErrBlob)
+		}
+	}
+	for l := 1; l <= maxCodeBits; l++ {
+		for sym, symLen := range lengths {
+			if int(symLen) == l {
+				h.symbol = append(h.symbol, uint16(sym))
+			}
+		}
+	}
+	return h, nil
+}
+
+// decode reads a single Huffman-coded symbol from br.
+func (h *huffman) decode(br *bitReader) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		bit, err := br.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[l])
+		if code-count < first {
+			return int(h.symbol[index+(code-first)]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, fmt.Errorf("%w: invalid huffman code", ErrBlob)
+}
+
+// The decoding tables for fixed Huffman blocks never change, so we build them once.
+var fixedLitLen, fixedDist = fixedHuffman()
+
+// fixedHuffman returns the decoding tables for fixed Huffman blocks (BTYPE=01).
+func fixedHuffman() (*huffman, *huffman) {
+	var lengths [maxLitLenSyms]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	litLen, _ := newHuffman(lengths[:])
+	var distLengths [maxDistSyms]uint8
+	for i := range distLengths {
+		distLengths[i] = 5
+	}
+	dist, _ := newHuffman(distLengths[:])
+	return litLen, dist
+}
+
+// dynamicHuffman reads the code definitions for a dynamic Huffman block (BTYPE=10).
+func dynamicHuffman(br *bitReader) (*huffman, *huffman, error) {
+	hlit, err := br.readBits(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdist, err := br.readBits(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	hclen, err := br.readBits(4)
+	if err != nil {
+		return nil, nil, err
+	}
+	nlen, ndist, ncode := int(hlit)+257, int(hdist)+1, int(hclen)+4
+	if nlen > 286 || ndist > maxDistSyms {
+		return nil, nil, fmt.Errorf("%w: bad dynamic block code counts", ErrBlob)
+	}
+
+	var codeLenLengths [numCodeLenSym]uint8
+	for i := range ncode {
+		v, err := br.readBits(3)
+		if err != nil {
+			return nil, nil, err
+		}
+		codeLenLengths[codeLenOrder[i]] = uint8(v)
+	}
+	codeLen, err := newHuffman(codeLenLengths[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lengths := make([]uint8, nlen+ndist)
+	for i := 0; i < nlen+ndist; {
+		sym, err := codeLen.decode(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var repeat uint32
+		var value uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return nil, nil, fmt.Errorf("%w: repeat with no previous code length", ErrBlob)
+			}
+			value = lengths[i-1]
+			repeat, err = br.readBits(2)
+			repeat += 3
+		case 17:
+			repeat, err = br.readBits(3)
+			repeat += 3
+		default:
+			repeat, err = br.readBits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if i+int(repeat) > nlen+ndist {
+			return nil, nil, fmt.Errorf("%w: too many code lengths", ErrBlob)
+		}
+		for range repeat {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return nil, nil, fmt.Errorf("%w: missing end-of-block code", ErrBlob)
+	}
+
+	litLen, err := newHuffman(lengths[:nlen])
+	if err != nil {
+		return nil, nil, err
+	}
+	dist, err := newHuffman(lengths[nlen:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return litLen, dist, nil
+}
+
+// skipCodes walks the symbols of a Huffman-coded block, up to and including
+// its end-of-block code, and reports whether the block held any data.
+func skipCodes(br *bitReader, litLen, dist *huffman) (bool, error) {
+	hasData := false
+	for {
+		sym, err := litLen.decode(br)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case sym < 256: // Literal byte.
+			hasData = true
+			continue
+		case sym == 256: // End of block.
+			return hasData, nil
+		case sym > 285:
+			return false, fmt.Errorf("%w: invalid length symbol", ErrBlob)
+		}
+		hasData = true
+		if _, err := br.readBits(int(lengthExtraBits[sym-257])); err != nil {
+			return false, err
+		}
+		distSym, err := dist.decode(br)
+		if err != nil {
+			return false, err
+		}
+		if distSym >= maxDistSyms {
+			return false, fmt.Errorf("%w: invalid distance symbol", ErrBlob)
+		}
+		if _, err := br.readBits(int(distExtraBits[distSym])); err != nil {
+			return false, err
+		}
+	}
+}
+
+// scanDeflate walks the blocks of the DEFLATE stream at the start of p,
+// and reports where the stream ends, and where its final block sits.
+// Any bytes in p past the end of the stream are ignored.
+func scanDeflate(p []byte) (deflateScan, error) {
+	return scanBlocks(&bitReader{p: p})
+}
+
+// scanBlocks walks the blocks of the DEFLATE stream read by br. When br reads
+// from a stream, the BFINAL bit of the final block is cleared in the window as
+// it is found, before that byte can be written to br.dst.
+func scanBlocks(br *bitReader) (deflateScan, error) {
+	hasData := false
+	for {
+		blockStart := br.offset()
+		final, err := br.readBits(1)
+		if err != nil {
+			return deflateScan{}, err
+		}
+		if final == 1 && br.src != nil {
+			bit := br.pos - 1
+			br.p[bit/8] &^= 1 << (bit % 8)
+		}
+		blockType, err := br.readBits(2)
+		if err != nil {
+			return deflateScan{}, err
+		}
+
+		switch blockType {
+		case 0: // Stored block.
+			br.alignToByte()
+			lengths, err := br.readBits(32)
+			if err != nil {
+				return deflateScan{}, err
+			}
+			length, nlength := lengths&0xffff, lengths>>16
+			if length != ^nlength&0xffff {
+				return deflateScan{}, fmt.Errorf("%w: stored block length mismatch", ErrBlob)
+			}
+			if err := br.skipBytes(int(length)); err != nil {
+				return deflateScan{}, err
+			}
+			hasData = hasData || length > 0
+		case 1: // Fixed Huffman block.
+			blockHasData, err := skipCodes(br, fixedLitLen, fixedDist)
+			if err != nil {
+				return deflateScan{}, err
+			}
+			hasData = hasData || blockHasData
+		case 2: // Dynamic Huffman block.
+			litLen, dist, err := dynamicHuffman(br)
+			if err != nil {
+				return deflateScan{}, err
+			}
+			blockHasData, err := skipCodes(br, litLen, dist)
+			if err != nil {
+				return deflateScan{}, err
+			}
+			hasData = hasData || blockHasData
+		default:
+			return deflateScan{}, fmt.Errorf("%w: invalid deflate block type", ErrBlob)
+		}
+
+		if final == 1 {
+			return deflateScan{
+				length:        (br.offset() + 7) / 8,
+				finalBlockBit: blockStart,
+				finalBlockEnd: br.offset(),
+				hasData:       hasData,
+			}, nil
+		}
+	}
+}
+
+// Splicing DEFLATE streams together takes more than concatenating them: every
+// stream ends with a block that has its BFINAL bit set, and decoders stop
+// there. To let more data follow a stream, the BFINAL bit is cleared, and an
+// empty, non-final stored block is appended right after the final block. That
+// block also pads the stream out to a byte boundary, so the result ends
+// exactly like a stream that was sync flushed (Z_SYNC_FLUSH).
+
+// spliceTailSize is the most bytes spliceTail can produce.
+const spliceTailSize = 6
+
+// spliceTail returns the end of a spliced DEFLATE stream, starting from the
+// byte that holds the end of its final block, at bit offset end. The partial
+// byte is ignored if end is byte-aligned. The result is built in buf.
+func spliceTail(buf *[spliceTailSize]byte, partial byte, end int) []byte {
+	n := 0
+	if used := end % 8; used != 0 {
+		// Keep the used bits, and zero the rest, which are where the stored
+		// block header goes. If the 3-bit header does not fit, it spills over
+		// into the next byte.
+		buf[n] = partial & (1<<used - 1)
+		n++
+		if used > 5 {
+			buf[n] = 0
+			n++
+		}
+	} else {
+		buf[n] = 0
+		n++
+	}
+	// LEN and NLEN of the empty stored block.
+	n += copy(buf[n:], []byte{0x00, 0x00, 0xff, 0xff})
+	return buf[:n]
+}
+
+// spliceDeflate returns the pieces that, written in order, form the spliced
+// version of the DEFLATE stream in content, which was scanned into scan.
+// Only the bytes that change are copied, into buf, so content is unmodified.
+func spliceDeflate(buf *[spliceTailSize + 1]byte, content []byte, scan deflateScan) [4][]byte {
+	// A block is at least 10 bits long, so the first byte of the final block
+	// always comes before the byte holding the end of the final block.
+	first := scan.finalBlockBit / 8
+	last := scan.finalBlockEnd / 8
+
+	buf[0] = content[first] &^ (1 << (scan.finalBlockBit % 8))
+	var partial byte
+	if last < len(content) {
+		partial = content[last]
+	}
+	tail := spliceTail((*[spliceTailSize]byte)(buf[1:]), partial, scan.finalBlockEnd)
+	return [4][]byte{content[:first], buf[:1], content[first+1 : last], tail}
+}
+
+// spliceDeflateStream copies the DEFLATE stream read from src to dst, spliced
+// the same way as spliceDeflate does. Reads from src are buffered, so src must
+// end where the stream does.
+func spliceDeflateStream(dst io.Writer, src io.Reader) (deflateScan, error) {
+	br := bitReader{
+		p:   make([]byte, 0, 32*1024),
+		src: src,
+		dst: dst,
+	}
+	scan, err := scanBlocks(&br)
+	if err != nil {
+		return deflateScan{}, err
+	}
+
+	// Write out the rest of the window, up to the end of the final block.
+	end := scan.finalBlockEnd - br.base*8
+	last := end / 8
+	var partial byte
+	if last < len(br.p) {
+		partial = br.p[last]
+	}
+	var buf [spliceTailSize]byte
+	tail := spliceTail(&buf, partial, end)
+	for _, piece := range [][]byte{br.p[:last], tail} {
+		if _, err := dst.Write(piece); err != nil {
+			return deflateScan{}, fmt.Errorf("gzip: failed to write deflate data: %w", err)
+		}
+	}
+	return scan, nil
+}
+
+// maxStoredBlockSize is the most data a single stored block can hold, since
+// its LEN field is 16 bits.
+const maxStoredBlockSize = 0xffff
+
+// storedBlocks returns the pieces that, written in order, form a sequence of
+// non-final stored blocks holding p. The stream they are written into must
+// be at a byte boundary, and stays at one after them. The block headers are
+// allocated in one piece, and the data pieces are subslices of p.
+func storedBlocks(p []byte) [][]byte {
+	count := (len(p) + maxStoredBlockSize - 1) / maxStoredBlockSize
+	headers := make([]byte, 5*count)
+	pieces := make([][]byte, 0, 2*count)
+	for i := range count {
+		data := p[i*maxStoredBlockSize : min(len(p), (i+1)*maxStoredBlockSize)]
+		// BFINAL and BTYPE are all zero bits, padded out to the byte boundary,
+		// then come LEN and NLEN.
+		header := headers[5*i : 5*i+5]
+		header[0] = 0
+		binary.LittleEndian.PutUint16(header[1:3], uint16(len(data)))
+		binary.LittleEndian.PutUint16(header[3:5], ^uint16(len(data)))
+		pieces = append(pieces, header, data)
+	}
+	return pieces
+}
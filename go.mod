@@ -2,4 +2,7 @@ module github.com/philipaconrad/gzipstreamwriter
 
 go 1.24
 
-require github.com/google/go-cmp v0.7.0
+require (
+	github.com/google/go-cmp v0.7.0
+	github.com/klauspost/compress v1.19.2
+)
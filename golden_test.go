@@ -0,0 +1,117 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenCases lists the golden files in testdata/golden, and the blobs from
+// testdata/blobs that each one is assembled from, in order.
+var goldenCases = []struct {
+	name  string
+	blobs []string
+}{
+	{
+		name:  "stdlib-single",
+		blobs: []string{"stdlib-default.gz"},
+	},
+	{
+		name:  "stdlib-multi",
+		blobs: []string{"stdlib-bestspeed-header.gz", "stdlib-default.gz", "stdlib-empty.gz", "stdlib-bestspeed-header.gz"},
+	},
+	{
+		name:  "klauspost-multi",
+		blobs: []string{"klauspost-default.gz", "klauspost-bestcompression-header.gz", "klauspost-empty.gz", "klauspost-huffmanonly.gz"},
+	},
+	{
+		name:  "mixed",
+		blobs: []string{"klauspost-empty.gz", "stdlib-default.gz", "klauspost-bestcompression-header.gz", "stdlib-empty.gz", "klauspost-default.gz"},
+	},
+}
+
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			blobs := make([][]byte, 0, len(tc.blobs))
+			var expResult []byte
+			for _, name := range tc.blobs {
+				blob, err := os.ReadFile(filepath.Join("testdata", "blobs", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				blobs = append(blobs, blob)
+				data, err := gzipstreamwriter.DecompressAll(bytes.NewReader(blob))
+				if err != nil {
+					t.Fatalf("%s: expected no error, got %v", name, err)
+				}
+				expResult = append(expResult, data...)
+			}
+
+			actOutput, err := gzipstreamwriter.AssembleGolden(blobs)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			goldenPath := filepath.Join("testdata", "golden", tc.name+".gz")
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, actOutput, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expOutput, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The empty final block comes from compress/flate, and differs
+			// between Go versions, so it is not part of the comparison.
+			if diff := cmp.Diff(trimFinalBlock(expOutput), trimFinalBlock(actOutput)); diff != "" {
+				t.Fatalf("TestGolden() mismatch (-want +got):\n%s", diff)
+			}
+
+			// The golden output must also be a valid gzip stream.
+			result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actOutput))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(expResult, result) {
+				t.Fatalf("expected %d bytes of output, got %d bytes", len(expResult), len(result))
+			}
+		})
+	}
+}
+
+// finalBlocks are the empty final DEFLATE blocks that flate.Writer.Close has
+// been seen to write: a fixed Huffman block on newer Go versions, and a stored
+// block on older ones.
+var finalBlocks = [][]byte{
+	{0x03, 0x00},
+	{0x01, 0x00, 0x00, 0xff, 0xff},
+}
+
+// trimFinalBlock removes the empty final block before the trailer of the gzip
+// stream p, if there is one, so that streams written by different Go versions
+// can be compared.
+func trimFinalBlock(p []byte) []byte {
+	if len(p) < 8 {
+		return p
+	}
+	body, trailer := p[:len(p)-8], p[len(p)-8:]
+	for _, block := range finalBlocks {
+		if bytes.HasSuffix(body, block) {
+			return append(body[:len(body)-len(block):len(body)-len(block)], trailer...)
+		}
+	}
+	return p
+}
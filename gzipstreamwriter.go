@@ -8,15 +8,24 @@
 package gzipstreamwriter
 
 import (
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
+	"iter"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -28,6 +37,8 @@ const (
 	flagExtra   = 1 << 2
 	flagName    = 1 << 3
 	flagComment = 1 << 4
+	// Bits 5-7 are reserved, and must be zero. See StrictHeaders.
+	flagReserved = 0xe0
 )
 
 // These constants are copied from the flate package, so that code that imports
@@ -40,12 +51,35 @@ const (
 	HuffmanOnly        = flate.HuffmanOnly
 )
 
+// maxDictSize is the largest useful preset dictionary, which is the size of
+// the DEFLATE window.
+const maxDictSize = 32 * 1024
+
+// contextChunkSize is the size of the chunks WriteContext feeds to the
+// compressor, between checks for cancellation.
+const contextChunkSize = 32 * 1024
+
+// defaultReadChunkSize is the default size of the chunks ReadFrom reads from
+// its source. See WithReadChunkSize.
+const defaultReadChunkSize = 32 * 1024
+
 // The error types for the package.
 var (
 	ErrBlob                    = errors.New("gzip: invalid gzip blob")
 	ErrHdrNonLatin1            = errors.New("gzip: non-Latin-1 header string")
 	ErrHdrExtaDataTooLarge     = errors.New("gzip: extra data is too large")
 	ErrInvalidCompressionLevel = errors.New("gzip: invalid compression level")
+	ErrTruncatedHeader         = errors.New("gzip: truncated header")
+	ErrDictTooLarge            = errors.New("gzip: dictionary is larger than the 32 KB window")
+	ErrHeaderAlreadyWritten    = errors.New("gzip: header already written")
+	ErrAborted                 = errors.New("gzip: stream aborted")
+	ErrBlobIndex               = errors.New("gzip: blob index already submitted or negative")
+	ErrMissingBlobs            = errors.New("gzip: missing blobs")
+	ErrTooManyMembers          = errors.New("gzip: too many members")
+	ErrClosed                  = errors.New("gzip: write after close")
+	ErrOutputLimitExceeded     = errors.New("gzip: output limit exceeded")
+	ErrMixedLevels             = errors.New("gzip: blob compressed at a different level")
+	ErrInvalidState            = errors.New("gzip: invalid writer state")
 )
 
 // CompressedBlobWriter is the interface for writing pre-compressed gzip blobs.
@@ -119,52 +153,537 @@ type CompressedBlobWriter interface {
 // GzipStreamWriter is a GZIP writer that can write multiple compressed gzip blobs to the same output stream.
 type GzipStreamWriter struct {
 	gzip.Header // written at first call to Write, Flush, or Close
-	w           io.Writer
+	w           countingWriter
+	dst         io.Writer // Destination writer, as passed in, before any wrapping.
 	compressor  *flate.Writer
 	level       int
+	dict        []byte         // Preset dictionary for the compressor, if any.
+	buffered    *bufio.Writer  // Buffer in front of the destination writer, if any.
+	aligned     *alignedOutput // Holds the current member, if members are aligned.
+	crcTable    *crc32.Table   // Polynomial table for the running CRC32. Always IEEE for standard gzip.
+	options     writerOptions
 	err         error
 	digest      uint32
-	size        uint32
+	size        uint64  // Only the low 32 bits go in a standard trailer.
+	finalISIZE  *uint32 // Overrides size in the trailer written by Close, if set.
+	members     int     // Blobs written with WriteCompressed so far.
+	memberStart int64   // Output byte count at the start of the current member.
+	events      int     // Calls to the OnMember callback so far.
+
+	// Level that the compressor was created with. Only differs from level
+	// for stored members, with WithAutoLevel.
+	compressorLevel int
+	spare           *flate.Writer // Compressor for the other level, with WithAutoLevel.
+	pending         []byte        // Small writes held back, with WithWriteBuffer.
+	hash            hash.Hash     // Receives a copy of the output, if set.
 
 	// The stateFlags bitfield tracks
 	// 0: Have we written the Gzip header yet?
 	// 1: Has the stream been closed yet?
 	// 2: Are we writing into the DEFLATE stream currently? (Negated when we write compressed blobs.)
-	stateFlags uint32 // 0x1: wroteHeader, 0x2: closed, 0x4: activeDeflateStream
+	// 3: Could the compressor refer back to data (or a preset dictionary) that came before a spliced blob?
+	// 4: Was the compressor recreated without the preset dictionary, after a splice?
+	stateFlags uint32 // 0x1: wroteHeader, 0x2: closed, 0x4: activeDeflateStream, 0x8: compressorHistory, 0x10: droppedDict
+}
+
+// Option configures optional behavior of a GzipStreamWriter.
+// Options are applied when the writer is constructed, and are kept across calls to Reset.
+type Option func(*writerOptions)
+
+// writerOptions holds the settings that can be changed with an Option.
+type writerOptions struct {
+	verifyBlobs bool
+	xfl         *byte // Overrides the XFL header byte, if set.
+	maxMembers  int   // Limit on blobs passed to WriteCompressed, if positive.
+	retryPolicy *WriteRetryPolicy
+	// Pads members to a multiple of this many bytes, if positive.
+	memberAlignment int
+	readChunkSize   int // Size of the chunks ReadFrom reads, if positive.
+	// Members whose first write is smaller than this are stored, if positive.
+	autoLevelThreshold int
+	maxOutputBytes     int64 // Limit on bytes written to w, if positive.
+	text               bool  // Sets the FTEXT header flag.
+	onMember           func(index, compressedLen int, checksum uint32)
+	withoutTrailer     bool // Close leaves out the trailer of the last member.
+	writeBuffer        int  // Size of the buffer for small writes, if positive.
+	warnOnMixedLevels  bool // Rejects blobs whose XFL byte differs.
+	lazyHeaderOnFlush  bool // Flush does nothing until the first write.
+	extendedTrailer    bool // Trailers hold a 64-bit size.
+	// Compressors shared by parallel writers, if set.
+	flatePool *sync.Pool
+	// Sets the header's OS field from runtime.GOOS, instead of 255.
+	osFromRuntime bool
+	blobFilter    BlobFilter // Checks blobs before WriteCompressed, if set.
+	seamlessBlobs bool       // Blobs after raw writes are recompressed.
+	strictHeaders bool       // Rejects blobs with reserved header flag bits.
+}
+
+// VerifyBlobs enables strict verification of the blobs passed to WriteCompressed.
+// When enabled, each blob's DEFLATE payload is decompressed, and its CRC32 and
+// length are checked against the blob's trailer before anything is written.
+//
+// This gives up the main speed advantage of WriteCompressed (not decompressing
+// blobs), in exchange for catching corrupt blobs before they reach the output.
+// It is off by default.
+func VerifyBlobs(enabled bool) Option {
+	return func(o *writerOptions) {
+		o.verifyBlobs = enabled
+	}
+}
+
+// StrictHeaders makes WriteCompressed, WriteCompressedReader, and
+// WriteCompressedFrom reject blobs whose header has any of the reserved FLG
+// bits (0x20-0x80) set, with an error wrapping ErrBlob. Passed to
+// NewVerifyingReader, it makes the reader reject such members the same way.
+//
+// RFC 1952 requires these bits to be zero, so a header with one set is often
+// a corrupted blob that happens to start with valid magic bytes. Some
+// producers set them anyway, and the rest of the header parses fine without
+// them, so it is off by default, and the bits are ignored.
+func StrictHeaders(enabled bool) Option {
+	return func(o *writerOptions) {
+		o.strictHeaders = enabled
+	}
+}
+
+// WarnOnMixedLevels makes WriteCompressed, WriteCompressedReader, and
+// WriteCompressedFrom reject blobs that were compressed at a different level
+// than the stream, going by the XFL byte of their header, with an error
+// wrapping ErrMixedLevels. Nothing is written for such a blob.
+//
+// Mixing levels produces a valid stream, but one that compresses worse than
+// expected, or that has stored blocks where a strict reader does not expect
+// them. The XFL byte only tells BestCompression (2) and BestSpeed (4) apart
+// from every other level (0), so a blob compressed with NoCompression is only
+// caught in a stream that uses one of those two levels. It is off by default.
+func WarnOnMixedLevels(enabled bool) Option {
+	return func(o *writerOptions) {
+		o.warnOnMixedLevels = enabled
+	}
+}
+
+// WithOSFromRuntime sets the OS field of the header to match runtime.GOOS,
+// instead of 255 (unknown): 3 for Unix-like systems, including macOS, and 11
+// (NTFS) for Windows. Other systems keep 255. Tools such as "gzip -l" display
+// the field, but readers otherwise ignore it. The choice is kept across
+// Reset, but ResetWithHeader and direct changes to the Header override it.
+func WithOSFromRuntime() Option {
+	return func(o *writerOptions) {
+		o.osFromRuntime = true
+	}
+}
+
+// WithSeamlessBlobs makes WriteCompressed and WriteDeflate decompress a blob
+// that follows raw writes in the same member, and pass its data to Write,
+// instead of splicing the blob in. Normally, a spliced blob is preceded by a
+// sync flush, and the data written after it cannot refer back to the data
+// before it, which costs some compression at every seam between raw writes
+// and blobs. With this option, that seam disappears, and the output is the
+// same as if the blob's data had been passed to Write.
+//
+// This gives up the main speed advantage of WriteCompressed, for every blob
+// that follows raw writes: the blob is decompressed, checked against its
+// trailer like with VerifyBlobs, and compressed again, at the writer's level.
+// Blobs that start a member, or only follow other blobs, are still spliced,
+// since there is no seam to remove. A raw write right after a spliced blob
+// still cannot refer back to the blob's data. It is off by default.
+func WithSeamlessBlobs() Option {
+	return func(o *writerOptions) {
+		o.seamlessBlobs = true
+	}
+}
+
+// BlobFilter checks a blob before WriteCompressed writes it, and returns an
+// error if the blob must not be written. See WithBlobFilter.
+type BlobFilter func(blob []byte) error
+
+// WithBlobFilter makes WriteCompressed pass every blob to filter first, to
+// enforce a policy on the blobs that go into the stream, such as only
+// accepting blobs from known producers, going by their header. If filter
+// returns an error, WriteCompressed returns it as-is, and writes nothing.
+// The error is not sticky. The blob must not be modified by filter.
+//
+// The filter runs before any of the writer's own checks, so it may see
+// malformed blobs. It is not applied to WriteDeflate, or to the methods that
+// read blobs from an io.Reader, which never hold the whole blob in memory.
+func WithBlobFilter(filter BlobFilter) Option {
+	return func(o *writerOptions) {
+		o.blobFilter = filter
+	}
+}
+
+// WithXFL forces the XFL (extra flags) byte of the header to xfl.
+// By default, XFL is derived from the compression level, like the stdlib does:
+// 2 for BestCompression, 4 for BestSpeed, and 0 otherwise. This is for
+// consumers that compare whole headers, and expect a specific XFL value.
+func WithXFL(xfl byte) Option {
+	return func(o *writerOptions) {
+		o.xfl = &xfl
+	}
+}
+
+// WithMaxMembers caps the number of compressed blobs (gzip members) that can
+// be written with WriteCompressed and WriteCompressedReader. Once n blobs
+// have been written, later blobs are rejected with ErrTooManyMembers, and
+// nothing is written. The count starts over on Reset.
+// A limit of 0 or less means no limit, which is the default.
+func WithMaxMembers(n int) Option {
+	return func(o *writerOptions) {
+		o.maxMembers = n
+	}
+}
+
+// WithReadChunkSize sets the size of the chunks that ReadFrom reads from its
+// source, and compresses one at a time. The default is 32 KB.
+// Larger chunks mean fewer reads from fast sources, while smaller chunks use
+// less memory. Like [bufio.NewWriterSize], a size of 0 or less leaves the
+// default in place.
+func WithReadChunkSize(n int) Option {
+	return func(o *writerOptions) {
+		if n > 0 {
+			o.readChunkSize = n
+		}
+	}
+}
+
+// WithAutoLevel stores members uncompressed (as with NoCompression), when
+// compressing them would likely cost more than it saves. The level is picked
+// once per member, from the size of the member's first Write call: below
+// threshold bytes, the member is stored, otherwise it is compressed at the
+// writer's level. Members started by anything other than a Write with data
+// (such as WriteCompressed, or Flush) keep the previous member's level, or
+// the writer's level after a Reset.
+//
+// Since this switches between two compressors, it uses more memory.
+// Writers with a preset dictionary ignore it, since small payloads are where
+// a dictionary helps the most. A threshold of 0 or less disables it, which
+// is the default.
+func WithAutoLevel(threshold int) Option {
+	return func(o *writerOptions) {
+		o.autoLevelThreshold = threshold
+	}
+}
+
+// WithText sets the FTEXT flag in the header, which signals that the content
+// is probably ASCII text. Per RFC 1952, section 2.3.1, this is only a hint:
+// decoders decompress the data the same either way, but some tools use it
+// when displaying the content. It is off by default.
+func WithText(enabled bool) Option {
+	return func(o *writerOptions) {
+		o.text = enabled
+	}
+}
+
+// OnMember sets a callback, which is called after each blob is written with
+// WriteCompressed (or WriteDeflate, or WriteCompressedReader), and after each
+// member is finished by NextMember, FlushMember, or Close. This is meant for
+// metrics, such as the distribution of member sizes.
+//
+// The index counts calls to the callback, starting at 0, and starting over
+// on Reset. For a blob, compressedLen is the number of bytes the call wrote
+// to the underlying writer, and checksum is the blob's CRC32. For a finished
+// member, compressedLen is the size of the whole member, from its header to
+// its trailer, and checksum is the member's CRC32, as in its trailer.
+//
+// The callback runs synchronously, on the goroutine that made the call.
+// PositionalBlobAssembler never calls it while holding one of its internal
+// locks, so the callback may call back into it, but it may be called from
+// several goroutines at once, with indexes out of order.
+func OnMember(fn func(index, compressedLen int, checksum uint32)) Option {
+	return func(o *writerOptions) {
+		o.onMember = fn
+	}
+}
+
+// WithMaxOutputBytes caps the number of bytes written to the underlying
+// writer. Calls that would go over the limit return an error wrapping
+// ErrOutputLimitExceeded, before writing anything. This lets a sharding layer
+// start a new output before a large blob blows past its size threshold.
+//
+// The size of blobs (and WriteStored data) is known up front, so they are
+// rejected if they do not fit. WriteCompressedReader only knows an upper bound
+// of the blob's size, a few bytes more than its DEFLATE payload, and checks
+// that. The compressed size of data passed to Write is not known until it
+// is flushed, so Write is only rejected once the limit has been reached.
+// With WithMemberAlignment, the padding the current member needs at its
+// size after the call is counted too.
+// Close does not check the limit, so leave room for the end of the DEFLATE
+// stream, the 8-byte trailer, and any extra alignment padding that they
+// cause. The count starts over on Reset.
+// A limit of 0 or less means no limit, which is the default.
+func WithMaxOutputBytes(n int64) Option {
+	return func(o *writerOptions) {
+		o.maxOutputBytes = n
+	}
+}
+
+// WithoutTrailer makes Close end the last member's DEFLATE stream, but leave
+// out its 8-byte CRC32/ISIZE trailer. This is for nesting the writer's output
+// inside a larger gzip stream that is assembled elsewhere, which then writes
+// a combined trailer itself. The CRC32 and ISIZE that the trailer would have
+// held are returned by Digest after Close. Members finished by NextMember or
+// FlushMember still get their trailers.
+func WithoutTrailer() Option {
+	return func(o *writerOptions) {
+		o.withoutTrailer = true
+	}
+}
+
+// WithExtendedTrailer makes every trailer 12 bytes long instead of 8: the
+// CRC32, followed by the length of the member's uncompressed data as an 8-byte
+// little-endian integer, instead of the 4-byte ISIZE, which wraps around at
+// 4 GB. This is for private protocols whose consumers understand the
+// extension.
+//
+// WARNING: This produces non-compliant gzip output, which does not follow
+// RFC 1952, and which standard readers (including the stdlib gzip.Reader)
+// reject. The size of each blob passed to WriteCompressed is taken from its
+// own ISIZE field, so the size is only exact if every blob holds less than
+// 4 GB of data.
+func WithExtendedTrailer() Option {
+	return func(o *writerOptions) {
+		o.extendedTrailer = true
+	}
+}
+
+// WithWriteBuffer collects writes smaller than size bytes in a buffer of
+// that size, and only passes them on to the compressor once it is full, or
+// the stream is flushed. This cuts the per-call overhead of many tiny
+// writes, such as one per logged event, at the cost of one extra copy.
+// Larger writes go to the compressor directly, after the buffered data.
+// The output is the same as without the buffer. A size of 0 or less disables
+// buffering, which is the default.
+func WithWriteBuffer(size int) Option {
+	return func(o *writerOptions) {
+		o.writeBuffer = size
+	}
+}
+
+// WithLazyHeaderOnFlush makes Flush (and FlushN) a no-op until the first
+// write, so that the header is only written once real data arrives. This
+// suits keep-alive loops that flush on a timer, whether or not anything was
+// written. Otherwise, like the stdlib gzip.Writer, a Flush before any write
+// emits the header and an empty sync block. It is off by default.
+func WithLazyHeaderOnFlush(enabled bool) Option {
+	return func(o *writerOptions) {
+		o.lazyHeaderOnFlush = enabled
+	}
 }
 
 // NewGzipStreamWriter creates a new GzipStreamWriter with the default compression level.
-func NewGzipStreamWriter(w io.Writer) *GzipStreamWriter {
-	z, _ := NewGzipStreamWriterLevel(w, DefaultCompression)
+func NewGzipStreamWriter(w io.Writer, opts ...Option) *GzipStreamWriter {
+	z, _ := NewGzipStreamWriterLevel(w, DefaultCompression, opts...)
 	return z
 }
 
 // NewGzipStreamWriterLevel creates a new GzipStreamWriter with the specified compression level.
-func NewGzipStreamWriterLevel(w io.Writer, level int) (*GzipStreamWriter, error) {
+// Any level from HuffmanOnly to BestCompression is accepted.
+func NewGzipStreamWriterLevel(w io.Writer, level int, opts ...Option) (*GzipStreamWriter, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, level)
+	}
+	z := new(GzipStreamWriter)
+	z.applyOptions(opts)
+	z.init(w, level)
+	return z, nil
+}
+
+// NewGzipStreamWriterLevelHeader creates a new GzipStreamWriter with the
+// specified compression level, and header h. Both are validated up front,
+// rather than on the first write: an out-of-range level returns
+// ErrInvalidCompressionLevel, header strings that are not valid Latin-1 return
+// ErrHdrNonLatin1, and an Extra field over 0xffff bytes returns
+// ErrHdrExtaDataTooLarge.
+// As with ResetWithHeader, h is applied as given, so a zero OS field means FAT.
+func NewGzipStreamWriterLevelHeader(w io.Writer, level int, h gzip.Header, opts ...Option) (*GzipStreamWriter, error) {
+	if err := validateHeader(h); err != nil {
+		return nil, err
+	}
+	z, err := NewGzipStreamWriterLevel(w, level, opts...)
+	if err != nil {
+		return nil, err
+	}
+	z.Header = h
+	return z, nil
+}
+
+// NewGzipStreamWriterDict creates a new GzipStreamWriter with the specified
+// compression level, that compresses using a preset dictionary. The dictionary
+// is kept across calls to Reset.
+//
+// Gzip has no way to signal the use of a preset dictionary, so the output can
+// only be decompressed by a reader that is given the same dictionary, such as
+// a [flate.NewReaderDict] reader over the DEFLATE payload.
+// Blobs passed to WriteCompressed must have been compressed with the same
+// dictionary, or decompression of the output will fail.
+//
+// It validates its arguments in the same way as NewGzipStreamWriterLevelDict.
+func NewGzipStreamWriterDict(w io.Writer, level int, dict []byte, opts ...Option) (*GzipStreamWriter, error) {
+	return NewGzipStreamWriterLevelDict(w, level, dict, opts...)
+}
+
+// NewGzipStreamWriterLevelDict creates a new GzipStreamWriter with the
+// specified compression level and preset dictionary, validating both up front.
+// An out-of-range level returns ErrInvalidCompressionLevel. A dictionary larger
+// than the 32 KB DEFLATE window returns ErrDictTooLarge, since flate would
+// otherwise silently use only its last 32 KB.
+// See NewGzipStreamWriterDict for how the output can be decompressed.
+func NewGzipStreamWriterLevelDict(w io.Writer, level int, dict []byte, opts ...Option) (*GzipStreamWriter, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, level)
+	}
+	if len(dict) > maxDictSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDictTooLarge, len(dict))
+	}
+	z := new(GzipStreamWriter)
+	z.dict = slices.Clone(dict)
+	z.applyOptions(opts)
+	z.init(w, level)
+	return z, nil
+}
+
+// NewGzipStreamWriterBuffered creates a new GzipStreamWriter with the default
+// compression level, that buffers its output to w.
+// This greatly reduces the number of small writes to w, which matters for
+// destinations where each Write is expensive, such as raw network sockets.
+//
+// Flush and Close also flush the buffer, so that all output has reached w by
+// the time they return. Close does not close w.
+func NewGzipStreamWriterBuffered(w io.Writer, opts ...Option) *GzipStreamWriter {
+	z := new(GzipStreamWriter)
+	z.buffered = bufio.NewWriter(w)
+	z.applyOptions(opts)
+	z.init(w, DefaultCompression)
+	return z
+}
+
+// NewStreamWriterChecksum creates a new GzipStreamWriter with the specified
+// compression level, whose trailer checksum uses the CRC32 polynomial from
+// table instead of IEEE, such as [crc32.Castagnoli]. A nil table means IEEE.
+//
+// The gzip format (RFC 1952) mandates the IEEE polynomial, so with any other
+// table the output is NOT standard gzip: stock gzip readers will reject it
+// with a checksum error. This exists for internal formats that reuse the gzip
+// framing with a different checksum. Blobs passed to WriteCompressed must
+// carry trailer checksums computed with the same table.
+func NewStreamWriterChecksum(w io.Writer, level int, table *crc32.Table, opts ...Option) (*GzipStreamWriter, error) {
 	if level < HuffmanOnly || level > BestCompression {
 		return nil, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, level)
 	}
 	z := new(GzipStreamWriter)
+	z.crcTable = table
+	z.applyOptions(opts)
 	z.init(w, level)
 	return z, nil
 }
 
+func (z *GzipStreamWriter) applyOptions(opts []Option) {
+	for _, opt := range opts {
+		opt(&z.options)
+	}
+}
+
 func (z *GzipStreamWriter) init(w io.Writer, level int) {
-	compressor := z.compressor
+	dst := w
+	w = z.destination(w)
+	compressor, spare := z.compressor, z.spare
+	if compressor != nil && z.compressorLevel != level {
+		// WithAutoLevel left the other compressor in use. A fresh writer
+		// starts at its own level, so a reset one does too.
+		compressor, spare = spare, compressor
+	}
+	if compressor != nil && z.checkDroppedDict() {
+		// Recreated with the dictionary by initCompressor.
+		compressor = nil
+	}
 	if compressor != nil {
-		compressor.Reset(w)
+		// Note: For compressors created with a preset dictionary, this also
+		// restores the dictionary.
+		compressor.Reset(sinkWriter{z})
+	}
+	buffered := z.buffered
+	if buffered != nil {
+		buffered.Reset(w)
+		w = buffered
+	}
+	var aligned *alignedOutput
+	if z.options.memberAlignment > 0 {
+		aligned = z.aligned
+		if aligned == nil {
+			aligned = &alignedOutput{}
+		}
+		aligned.dst = w
+		aligned.member.Reset()
+		w = &aligned.member
+	}
+	crcTable := z.crcTable
+	if crcTable == nil {
+		crcTable = crc32.IEEETable
 	}
 
 	*z = GzipStreamWriter{
 		Header: gzip.Header{
 			OS: 255, // unknown
 		},
-		w:          w,
+		w:          countingWriter{w: w},
+		dst:        dst,
 		level:      level,
+		dict:       z.dict,
+		buffered:   buffered,
+		aligned:    aligned,
+		crcTable:   crcTable,
+		options:    z.options,
 		compressor: compressor,
+
+		compressorLevel: level,
+		spare:           spare,
+		pending:         z.pending[:0],
+		hash:            z.hash,
+	}
+	if z.options.osFromRuntime {
+		z.OS = osForGOOS(runtime.GOOS)
+	}
+}
+
+// osForGOOS returns the RFC 1952 OS code for a GOOS value, or 255 (unknown)
+// if there is none. Every Unix-like system, including macOS, gets the Unix
+// code, like GNU gzip writes there. The Macintosh code (7) is for the classic
+// Mac OS, which Go does not run on.
+func osForGOOS(goos string) byte {
+	switch goos {
+	case "aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos",
+		"ios", "linux", "netbsd", "openbsd", "solaris":
+		return 3 // Unix
+	case "windows":
+		return 11 // NTFS
+	default:
+		return 255 // unknown
 	}
 }
 
+// sinkWriter forwards writes to the current destination of a GzipStreamWriter.
+// The compressor writes through it, so that SetWriter can redirect the
+// compressor's output without resetting it.
+type sinkWriter struct {
+	z *GzipStreamWriter
+}
+
+func (s sinkWriter) Write(p []byte) (int, error) {
+	return s.z.w.Write(p)
+}
+
+// countingWriter forwards writes to w, and counts the bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err //nolint:wrapcheck
+}
+
 func (z *GzipStreamWriter) setWroteHeader(value bool) {
 	flag := uint32(0)
 	if value {
@@ -189,6 +708,22 @@ func (z *GzipStreamWriter) setActiveDeflateStream(value bool) {
 	z.stateFlags = (z.stateFlags & ^uint32(0x0004)) | (flag << 2)
 }
 
+func (z *GzipStreamWriter) setCompressorHistory(value bool) {
+	flag := uint32(0)
+	if value {
+		flag = 1
+	}
+	z.stateFlags = (z.stateFlags & ^uint32(0x0008)) | (flag << 3)
+}
+
+func (z *GzipStreamWriter) setDroppedDict(value bool) {
+	flag := uint32(0)
+	if value {
+		flag = 1
+	}
+	z.stateFlags = (z.stateFlags & ^uint32(0x0010)) | (flag << 4)
+}
+
 func (z *GzipStreamWriter) checkWroteHeader() bool {
 	flag := z.stateFlags & 0x1
 	return flag == 1
@@ -204,121 +739,307 @@ func (z *GzipStreamWriter) checkActiveDeflateStream() bool {
 	return flag == 1
 }
 
+func (z *GzipStreamWriter) checkCompressorHistory() bool {
+	flag := (z.stateFlags & 0x8) >> 3
+	return flag == 1
+}
+
+func (z *GzipStreamWriter) checkDroppedDict() bool {
+	flag := (z.stateFlags & 0x10) >> 4
+	return flag == 1
+}
+
 func (z *GzipStreamWriter) writeHeader() (int, error) {
 	// Write the GZIP header lazily.
-	var n int
 	z.setWroteHeader(true)
-	buf := [10]byte{}
-	buf[0] = gzipID1
-	buf[1] = gzipID2
-	buf[2] = gzipDeflate
-	buf[3] = 0
-	if z.Extra != nil {
-		buf[3] |= 0x04
+	xfl := xflForLevel(z.compressorLevel)
+	if z.options.xfl != nil {
+		xfl = *z.options.xfl
 	}
-	if z.Name != "" {
-		buf[3] |= 0x08
+	var header []byte
+	header, z.err = buildHeader(z.Header, xfl, z.options.text)
+	if z.err != nil {
+		return 0, z.err
 	}
-	if z.Comment != "" {
-		buf[3] |= 0x10
+	var n int
+	n, z.err = z.w.Write(header)
+	if z.err != nil {
+		return n, z.err
+	}
+	z.initCompressor()
+	return n, nil
+}
+
+// buildHeader encodes h as a gzip header, with the given XFL byte, and the
+// FTEXT flag set if text is true. Name and Comment are converted to Latin-1.
+func buildHeader(h gzip.Header, xfl byte, text bool) ([]byte, error) {
+	if err := validateHeader(h); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 10, 10+2+len(h.Extra)+len(h.Name)+1+len(h.Comment)+1)
+	header[0] = gzipID1
+	header[1] = gzipID2
+	header[2] = gzipDeflate
+	if text {
+		header[3] |= flagText
+	}
+	if h.Extra != nil {
+		header[3] |= flagExtra
+	}
+	if h.Name != "" {
+		header[3] |= flagName
+	}
+	if h.Comment != "" {
+		header[3] |= flagComment
 	}
 	// Note: Some libraries like github.com/klauspost/compress/gzip choose to
 	// always write this field, which causes slight differences in header bytes
 	// versus the stdlib gzip implementation.
 	// Since this is a one-time cost for each GZIP stream, we go with the
 	// stdlib approach for sake of compatibility.
-	if z.ModTime.After(time.Unix(0, 0)) {
+	if h.ModTime.After(time.Unix(0, 0)) {
 		// Section 2.3.1, the zero value for MTIME means that the
 		// modified time is not set.
-		binary.LittleEndian.PutUint32(buf[4:8], uint32(z.ModTime.Unix()))
+		binary.LittleEndian.PutUint32(header[4:8], uint32(h.ModTime.Unix()))
 	}
-	switch z.level {
-	case BestCompression:
-		buf[8] = 2
-	case BestSpeed:
-		buf[8] = 4
-	default:
-		buf[8] = 0
+	header[8] = xfl
+	header[9] = h.OS
+	if h.Extra != nil {
+		header = binary.LittleEndian.AppendUint16(header, uint16(len(h.Extra)))
+		header = append(header, h.Extra...)
 	}
-	buf[9] = z.OS
-	n, z.err = z.w.Write(buf[:10])
-	if z.err != nil {
-		return n, z.err
+	if h.Name != "" {
+		header = appendHeaderString(header, h.Name)
 	}
-	if z.Extra != nil {
-		z.err = z.writeHeaderBytes(z.Extra)
-		if z.err != nil {
-			return n, z.err
-		}
+	if h.Comment != "" {
+		header = appendHeaderString(header, h.Comment)
 	}
-	if z.Name != "" {
-		z.err = z.writeHeaderString(z.Name)
-		if z.err != nil {
-			return n, z.err
+	return header, nil
+}
+
+// initCompressor creates the compressor, if it does not exist yet.
+func (z *GzipStreamWriter) initCompressor() {
+	if z.compressor == nil {
+		if z.dict != nil {
+			z.compressor, _ = flate.NewWriterDict(sinkWriter{z}, z.compressorLevel, z.dict)
+		} else {
+			z.compressor, _ = flate.NewWriter(sinkWriter{z}, z.compressorLevel)
 		}
 	}
-	if z.Comment != "" {
-		z.err = z.writeHeaderString(z.Comment)
-		if z.err != nil {
-			return n, z.err
-		}
+	// The preset dictionary is history, as far as splicing is concerned.
+	z.setCompressorHistory(z.dict != nil && !z.checkDroppedDict())
+}
+
+// PrepareCompressor creates the writer's compressor up front. Without it,
+// the compressor is created when the header is first written, so the first
+// Write, Flush, or Close pays for allocating it, which takes several hundred
+// kilobytes. Calling it on a writer that already has a compressor does
+// nothing. Reset keeps the compressor, so a reused writer only needs it once.
+func (z *GzipStreamWriter) PrepareCompressor() error {
+	if z.err != nil {
+		return z.err
 	}
 	if z.compressor == nil {
-		z.compressor, _ = flate.NewWriter(z.w, z.level)
+		z.initCompressor()
+	}
+	return nil
+}
+
+// selectLevel switches the compressor to the level for a new member, whose
+// first write is n bytes long. See WithAutoLevel.
+func (z *GzipStreamWriter) selectLevel(n int) {
+	level := z.level
+	if n < z.options.autoLevelThreshold {
+		level = NoCompression
+	}
+	if level == z.compressorLevel {
+		return
+	}
+	z.compressor, z.spare = z.spare, z.compressor
+	z.compressorLevel = level
+	if z.compressor != nil {
+		z.compressor.Reset(sinkWriter{z})
+	}
+	// Otherwise, it is created by writeHeader.
+}
+
+// flushWriteBuffer passes the writes held back by WithWriteBuffer on to the
+// compressor, and folds them into the running CRC32. Their size was already
+// counted when they were written.
+func (z *GzipStreamWriter) flushWriteBuffer() error {
+	if len(z.pending) == 0 {
+		return nil
+	}
+	z.digest = crc32.Update(z.digest, z.crcTable, z.pending)
+	_, z.err = z.compressor.Write(z.pending)
+	z.pending = z.pending[:0]
+	return z.err
+}
+
+// flushCompressor flushes the compressor, after passing it any writes held
+// back by WithWriteBuffer.
+func (z *GzipStreamWriter) flushCompressor() error {
+	if err := z.flushWriteBuffer(); err != nil {
+		return err
+	}
+	return z.compressor.Flush() //nolint:wrapcheck
+}
+
+// clearHistory makes the compressor forget everything it has seen, once a
+// blob was spliced into the DEFLATE stream after it. The decoder's window
+// then holds the blob's data instead, so back-references from later writes
+// into the earlier data would decode to the wrong bytes.
+func (z *GzipStreamWriter) clearHistory() {
+	if !z.checkCompressorHistory() {
+		return
+	}
+	if z.dict != nil && !z.checkDroppedDict() {
+		// Resetting would restore the dictionary, so the compressor is
+		// recreated without it, until the next Reset.
+		z.compressor, _ = flate.NewWriter(sinkWriter{z}, z.compressorLevel)
+		z.setDroppedDict(true)
+	} else {
+		z.compressor.Reset(sinkWriter{z})
+	}
+	z.setCompressorHistory(false)
+}
+
+// xflForLevel returns the XFL header byte for a compression level, following
+// the stdlib gzip implementation. RFC 1952 only defines 2 (slowest, maximum
+// compression) and 4 (fastest). Neither describes NoCompression or
+// HuffmanOnly, so those get 0, like every level in between.
+func xflForLevel(level int) byte {
+	switch level {
+	case BestCompression:
+		return 2
+	case BestSpeed:
+		return 4
+	default:
+		return 0
 	}
-	return n, z.err
 }
 
-// writeHeaderBytes writes a length-prefixed byte slice to z.w.
-func (z *GzipStreamWriter) writeHeaderBytes(b []byte) error {
-	if len(b) > 0xffff {
+// AddExtraSubfield appends a subfield to the header's Extra field.
+// Per RFC 1952, section 2.3.1.1, the Extra field is a sequence of subfields,
+// each made of a 2-byte ID (si1, si2), a 2-byte little-endian length, and the
+// subfield's data. Some formats (such as BGZF) rely on specific subfields.
+//
+// If the Extra field would grow past 0xffff bytes, ErrHdrExtaDataTooLarge is
+// returned, and the Extra field is left unchanged. If the header has already
+// been written, ErrHeaderAlreadyWritten is returned.
+func (z *GzipStreamWriter) AddExtraSubfield(si1, si2 byte, data []byte) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	if len(z.Extra)+4+len(data) > 0xffff {
 		return ErrHdrExtaDataTooLarge
 	}
-	var lengthPrefix [2]byte
-	binary.LittleEndian.PutUint16(lengthPrefix[:2], uint16(len(b)))
-	if _, err := z.w.Write(lengthPrefix[:2]); err != nil {
-		return fmt.Errorf("gzip: failed to write length prefix: %w", err)
+	var subfieldHeader [4]byte
+	subfieldHeader[0] = si1
+	subfieldHeader[1] = si2
+	binary.LittleEndian.PutUint16(subfieldHeader[2:4], uint16(len(data)))
+	z.Extra = append(z.Extra, subfieldHeader[:]...)
+	z.Extra = append(z.Extra, data...)
+	return nil
+}
+
+// SetName sets the Name field of the header.
+// Setting the embedded Header fields directly after the header has been
+// written silently has no effect. The Set* methods instead return
+// ErrHeaderAlreadyWritten in that case, and leave the header unchanged.
+func (z *GzipStreamWriter) SetName(name string) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	if err := validateHeaderString(name); err != nil {
+		return err
+	}
+	z.Name = name
+	return nil
+}
+
+// SetComment sets the Comment field of the header.
+// See SetName for details.
+func (z *GzipStreamWriter) SetComment(comment string) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	if err := validateHeaderString(comment); err != nil {
+		return err
+	}
+	z.Comment = comment
+	return nil
+}
+
+// SetModTime sets the ModTime field of the header.
+// See SetName for details.
+func (z *GzipStreamWriter) SetModTime(modTime time.Time) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	z.ModTime = modTime
+	return nil
+}
+
+// SetExtra sets the Extra field of the header.
+// See SetName for details.
+func (z *GzipStreamWriter) SetExtra(extra []byte) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	if len(extra) > 0xffff {
+		return ErrHdrExtaDataTooLarge
 	}
-	if _, err := z.w.Write(b); err != nil {
-		return fmt.Errorf("gzip: failed to write bytes: %w", err)
+	z.Extra = extra
+	return nil
+}
+
+// SetOS sets the OS field of the header.
+// See SetName for details.
+func (z *GzipStreamWriter) SetOS(os byte) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
 	}
+	z.OS = os
 	return nil
 }
 
-// writeHeaderString writes a UTF-8 string s in GZIP's format to z.w.
-// GZIP (RFC 1952) specifies that strings are NUL-terminated ISO 8859-1 (Latin-1).
-func (z *GzipStreamWriter) writeHeaderString(s string) error {
-	var err error
-	// GZIP stores Latin-1 strings; error if non-Latin-1; convert if non-ASCII.
-	needconv := false
+// validateHeaderString checks that s can be stored as a NUL-terminated
+// Latin-1 header string.
+func validateHeaderString(s string) error {
 	for _, v := range s {
 		if v == 0 || v > 0xff {
 			return ErrHdrNonLatin1
 		}
-		if v > 0x7f {
-			needconv = true
-		}
 	}
-	if needconv {
-		b := make([]byte, 0, len(s))
-		for _, v := range s {
-			b = append(b, byte(v))
-		}
-		_, err = z.w.Write(b)
-	} else {
-		_, err = io.WriteString(z.w, s)
+	return nil
+}
+
+// validateHeader checks that the fields of h can be written into a gzip header.
+func validateHeader(h gzip.Header) error {
+	if err := validateHeaderString(h.Name); err != nil {
+		return err
 	}
-	if err != nil {
-		return fmt.Errorf("gzip: failed to write header string: %w", err)
+	if err := validateHeaderString(h.Comment); err != nil {
+		return err
 	}
-	// GZIP strings are NUL-terminated.
-	_, err = z.w.Write([]byte{0})
-	if err != nil {
-		return fmt.Errorf("gzip: failed to write null terminator for header string: %w", err)
+	if len(h.Extra) > 0xffff {
+		return ErrHdrExtaDataTooLarge
 	}
 	return nil
 }
 
+// appendHeaderString appends a UTF-8 string s to header in GZIP's format.
+// GZIP (RFC 1952) specifies that strings are NUL-terminated ISO 8859-1 (Latin-1),
+// so s must have been checked with validateHeaderString.
+func appendHeaderString(header []byte, s string) []byte {
+	for _, v := range s {
+		header = append(header, byte(v))
+	}
+	// GZIP strings are NUL-terminated.
+	return append(header, 0)
+}
+
 // Write writes the byte slice to the Gzip output stream.
 // This will trigger a Flush call on the underlying compressor, emitting a sync marker at a minimum.
 func (z *GzipStreamWriter) Write(p []byte) (int, error) {
@@ -326,103 +1047,532 @@ func (z *GzipStreamWriter) Write(p []byte) (int, error) {
 		return 0, z.err
 	}
 
+	if limit := z.options.maxOutputBytes; limit > 0 && len(p) > 0 && z.w.n >= limit {
+		return 0, fmt.Errorf("%w: %d bytes written, limit is %d", ErrOutputLimitExceeded, z.w.n, limit)
+	}
+
 	var n int
 	if !z.checkWroteHeader() {
+		if z.options.autoLevelThreshold > 0 && z.dict == nil && len(p) > 0 {
+			z.selectLevel(len(p))
+		}
 		if n, z.err = z.writeHeader(); z.err != nil {
 			return n, z.err
 		}
 	}
 
-	z.size += uint32(len(p))
-	z.digest = crc32.Update(z.digest, crc32.IEEETable, p)
-
+	z.size += uint64(len(p))
 	z.setActiveDeflateStream(true)
-	if n, z.err = z.compressor.Write(p); z.err != nil {
-		return n, z.err
+	if len(p) > 0 {
+		z.setCompressorHistory(true)
+	}
+
+	if size := z.options.writeBuffer; len(p) < size {
+		if len(z.pending)+len(p) > size {
+			if err := z.flushWriteBuffer(); err != nil {
+				return 0, err
+			}
+		}
+		z.pending = append(z.pending, p...)
+		return len(p), nil
+	}
+	if err := z.flushWriteBuffer(); err != nil {
+		return 0, err
+	}
+	z.digest = crc32.Update(z.digest, z.crcTable, p)
+	if n, z.err = z.compressor.Write(p); z.err != nil {
+		return n, z.err
+	}
+	// Note: No forced flush here, we flush lazily instead.
+	// z.err = z.flushCompressor()
+	return n, z.err
+}
+
+// WriteBuffer writes the unread contents of b to the Gzip output stream, like
+// Write(b.Bytes()), but without changing b. No reference to b, or to its
+// contents, is kept once WriteBuffer returns: the data has been compressed,
+// or copied into the writer's own buffers. So b can go back to its pool (or
+// be reset and reused) as soon as the call returns, even while the stream is
+// still open.
+func (z *GzipStreamWriter) WriteBuffer(b *bytes.Buffer) (int, error) {
+	return z.Write(b.Bytes())
+}
+
+// WriteContext is like Write, but splits p into chunks, and checks ctx for
+// cancellation before compressing each one.
+// If ctx is canceled, the writer is left in a terminal error state, and the
+// context's error is returned from this and all later calls.
+func (z *GzipStreamWriter) WriteContext(ctx context.Context, p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	written := 0
+	for {
+		if z.err = ctx.Err(); z.err != nil {
+			return written, z.err
+		}
+		chunk := p[:min(len(p), contextChunkSize)]
+		n, err := z.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+		if len(p) == 0 {
+			return written, nil
+		}
+	}
+}
+
+// ReadFrom reads data from r until EOF, and compresses it into the stream,
+// like Write does. It reads in chunks of the size set by WithReadChunkSize.
+// It returns the number of bytes read from r. Any error other than io.EOF,
+// from reading or writing, is returned.
+//
+// This implements [io.ReaderFrom], so [io.Copy] uses it.
+func (z *GzipStreamWriter) ReadFrom(r io.Reader) (int64, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	chunkSize := defaultReadChunkSize
+	if z.options.readChunkSize > 0 {
+		chunkSize = z.options.readChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			if _, werr := z.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return total, fmt.Errorf("gzip: failed to read from source: %w", err)
+		}
 	}
-	// Note: No forced flush here, we flush lazily instead.
-	// z.err = z.compressor.Flush()
-	return n, z.err
 }
 
 // WriteCompressed writes a compressed gzip byte blob through to the underlying writer.
+//
+// The blob's DEFLATE payload is spliced into the current member, without being
+// decompressed. Only its block structure is walked, to find and clear the bit
+// that marks its last block as the end of the stream. A blob holding no data
+// (an empty member) is valid, and leaves the running CRC32 and size unchanged.
 func (z *GzipStreamWriter) WriteCompressed(p []byte) (int, error) {
+	n, _, err := z.WriteCompressedN(p)
+	return n, err
+}
+
+// WriteCompressedN is like WriteCompressed, but also returns the ISIZE field
+// of the blob's trailer, which is the length of its uncompressed data, modulo
+// 2^32. For blobs known to hold less than 4 GB, that is the exact length.
+// The ISIZE is only returned if the blob was written successfully.
+func (z *GzipStreamWriter) WriteCompressedN(p []byte) (int, uint32, error) {
+	if z.err != nil {
+		return 0, 0, z.err
+	}
+	if err := z.checkMemberLimit(); err != nil {
+		return 0, 0, err
+	}
+	if z.options.blobFilter != nil {
+		if err := z.options.blobFilter(p); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	content, trailerChecksum, trailerLength, err := TrimBlob(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	if z.options.strictHeaders {
+		if err := checkReservedFlags(p); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := z.checkMixedLevels(p); err != nil {
+		return 0, 0, err
+	}
+	n, err := z.WriteDeflate(content, trailerChecksum, trailerLength)
+	if err != nil {
+		return n, 0, err
+	}
+	return n, trailerLength, nil
+}
+
+// WriteMembers writes every blob yielded by seq with WriteCompressed, in
+// order, so that a producer can hand over blobs as it makes them, without
+// collecting them into a slice first. Like with WriteCompressed, the blobs are
+// spliced into the current member.
+//
+// Iteration stops at the first error, from either side. An error yielded by
+// seq is returned wrapped with the blob's index, and does not set the
+// writer's sticky error: the blobs before it are already written, and the
+// caller decides whether to continue the stream, or Abort it. An error from
+// WriteCompressed is returned wrapped the same way, and is sticky or not as
+// described there.
+func (z *GzipStreamWriter) WriteMembers(seq iter.Seq2[[]byte, error]) error {
+	if z.err != nil {
+		return z.err
+	}
+	i := 0
+	for blob, err := range seq {
+		if err != nil {
+			return fmt.Errorf("blob %d: %w", i, err)
+		}
+		if _, err := z.WriteCompressed(blob); err != nil {
+			return fmt.Errorf("blob %d: %w", i, err)
+		}
+		i++
+	}
+	return nil
+}
+
+// WriteDeflate writes a raw DEFLATE stream through to the underlying writer,
+// along with the CRC32 and ISIZE fields that its gzip trailer would hold.
+// It is the lowest-overhead way to write pre-compressed data, for pipelines
+// that store DEFLATE payloads separately from their gzip framing, as split
+// apart by TrimBlob. Otherwise, it works exactly like WriteCompressed.
+//
+// The trailer fields are checked against the stream where feasible: a stream
+// that holds no data must come with a zero checksum and size. A mismatch
+// returns an error wrapping ErrBlob.
+func (z *GzipStreamWriter) WriteDeflate(deflate []byte, checksum, isize uint32) (int, error) {
 	if z.err != nil {
 		return 0, z.err
 	}
+	if err := z.checkMemberLimit(); err != nil {
+		return 0, err
+	}
+	if z.options.seamlessBlobs && z.checkCompressorHistory() {
+		return z.writeInflated(deflate, checksum, isize)
+	}
+	if z.options.verifyBlobs {
+		if err := z.verifyBlob(deflate, checksum, isize); err != nil {
+			return 0, err
+		}
+	}
 
+	var buf [spliceTailSize + 1]byte
+	pieces, err := prepareDeflate(&buf, deflate, checksum, isize)
+	if err != nil {
+		return 0, err
+	}
+	if err := z.checkOutputLimit(piecesLength(pieces[:])); err != nil {
+		return 0, err
+	}
+
+	// We would flush if we could here, but z.w is an io.Writer, and those do
+	// not have to implement Flush().
+	start := z.w.n
+	n, err := z.writeDeflate(checksum, isize, pieces[:]...)
+	if err != nil {
+		return n, err
+	}
+	z.members++
+	z.memberEvent(int(z.w.n-start), checksum)
+	return n, nil
+}
+
+// memberEvent calls the OnMember callback, if one is set.
+func (z *GzipStreamWriter) memberEvent(compressedLen int, checksum uint32) {
+	if z.options.onMember != nil {
+		z.options.onMember(z.events, compressedLen, checksum)
+		z.events++
+	}
+}
+
+// WriteStored writes p into the current member as DEFLATE stored blocks,
+// which hold the data as-is. This skips the compressor entirely, for data
+// that is known to be incompressible (such as images, or data that was
+// already compressed), and is much cheaper than Write at NoCompression.
+//
+// Each stored block adds 5 bytes of overhead per 64 KB of data. Like
+// WriteCompressed, it flushes the current deflate stream first, and later
+// writes cannot refer back to data from before it.
+func (z *GzipStreamWriter) WriteStored(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	pieces := storedBlocks(p)
+	if err := z.checkOutputLimit(piecesLength(pieces)); err != nil {
+		return 0, err
+	}
+	// The data is at hand, so the running CRC32 is updated directly, which is
+	// cheaper than combining in a separate checksum.
+	if _, err := z.writeDeflate(0, 0, pieces...); err != nil {
+		return 0, err
+	}
+	z.size += uint64(len(p))
+	z.digest = crc32.Update(z.digest, z.crcTable, p)
+	return len(p), nil
+}
+
+// piecesLength returns the total length of pieces.
+func piecesLength(pieces [][]byte) int {
+	length := 0
+	for _, piece := range pieces {
+		length += len(piece)
+	}
+	return length
+}
+
+// prepareDeflate checks a raw DEFLATE stream and its trailer fields, and
+// splices it into pieces that can be written into the middle of a stream.
+// The modified bytes are stored in buf.
+func prepareDeflate(buf *[spliceTailSize + 1]byte, deflate []byte, checksum, isize uint32) ([4][]byte, error) {
+	// The DEFLATE stream ends with a final block, which would end the whole
+	// member for decoders. It has to be spliced, so that more data can
+	// follow it.
+	scan, err := scanDeflate(deflate)
+	if err != nil {
+		return [4][]byte{}, err
+	}
+	if scan.length != len(deflate) {
+		return [4][]byte{}, fmt.Errorf("%w: trailing data after deflate stream", ErrBlob)
+	}
+	// An ISIZE of zero with data is allowed, since ISIZE wraps at 4 GB.
+	if !scan.hasData && (checksum != 0 || isize != 0) {
+		return [4][]byte{}, fmt.Errorf("%w: trailer does not match empty deflate stream", ErrBlob)
+	}
+	return spliceDeflate(buf, deflate, scan), nil
+}
+
+// checkOutputLimit returns an error if writing n more bytes (and the header,
+// if it has not been written yet) would go over the limit set with
+// WithMaxOutputBytes.
+func (z *GzipStreamWriter) checkOutputLimit(n int) error {
+	limit := z.options.maxOutputBytes
+	if limit <= 0 {
+		return nil
+	}
+	// Flush the current deflate stream, so that its output is counted.
+	// Writing the data would flush it anyway.
+	if z.checkActiveDeflateStream() {
+		if z.err = z.flushCompressor(); z.err != nil {
+			return z.err
+		}
+		z.setActiveDeflateStream(false)
+	}
+	total := z.w.n + int64(n)
+	if !z.checkWroteHeader() {
+		total += int64(z.headerSize())
+	}
+	total += z.alignmentPadding(total - z.w.n)
+	if total > limit {
+		return fmt.Errorf("%w: writing %d bytes would bring output to %d, limit is %d", ErrOutputLimitExceeded, n, total, limit)
+	}
+	return nil
+}
+
+// checkReservedFlags returns an error wrapping ErrBlob if the gzip header has
+// any reserved flag bits set.
+func checkReservedFlags(header []byte) error {
+	if flag := header[3]; flag&flagReserved != 0 {
+		return fmt.Errorf("%w: reserved flag bits set: %#02x", ErrBlob, flag)
+	}
+	return nil
+}
+
+// checkMixedLevels returns an error if the blob with the given header was
+// compressed at a different level than the stream, as set with
+// WarnOnMixedLevels.
+func (z *GzipStreamWriter) checkMixedLevels(header []byte) error {
+	if !z.options.warnOnMixedLevels {
+		return nil
+	}
+	if xfl := xflForLevel(z.compressorLevel); header[8] != xfl {
+		return fmt.Errorf("%w: blob has XFL %d, stream has XFL %d", ErrMixedLevels, header[8], xfl)
+	}
+	return nil
+}
+
+// checkMemberLimit returns an error if writing another blob would go over the
+// limit set with WithMaxMembers.
+func (z *GzipStreamWriter) checkMemberLimit() error {
+	if z.options.maxMembers > 0 && z.members >= z.options.maxMembers {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyMembers, z.options.maxMembers)
+	}
+	return nil
+}
+
+// writeDeflate writes pieces of raw DEFLATE data into the stream, and folds
+// the CRC32 and length of the uncompressed data they hold into the running
+// digest and size. The pieces must not end the DEFLATE stream, and must end
+// on a byte boundary.
+func (z *GzipStreamWriter) writeDeflate(checksum, length uint32, pieces ...[]byte) (int, error) {
 	var n int
-	if n, z.err = z.writeHeader(); z.err != nil {
-		return n, z.err
+	if !z.checkWroteHeader() {
+		if n, z.err = z.writeHeader(); z.err != nil {
+			return n, z.err
+		}
 	}
 
 	// Flush the current deflate stream, if one was active.
 	if z.checkActiveDeflateStream() {
-		if z.err = z.compressor.Flush(); z.err != nil {
+		if z.err = z.flushCompressor(); z.err != nil {
 			return n, z.err
 		}
 		z.setActiveDeflateStream(false)
 	}
+	z.clearHistory()
+
+	z.size += uint64(length)
+	z.digest = crc32Combine(z.crcTable, z.digest, checksum, int(length))
+	for _, piece := range pieces {
+		var m int
+		m, z.err = z.w.Write(piece)
+		n += m
+		if z.err != nil {
+			return n, z.err
+		}
+	}
+	return n, nil
+}
 
+// TrimBlob splits a single-member gzip blob into its raw DEFLATE payload, and
+// the CRC32 and ISIZE fields from its trailer. The payload is a subslice of p.
+//
+// This is the parsing half of WriteCompressed, exposed for building custom
+// concatenation pipelines. An invalid blob returns an error wrapping ErrBlob.
+func TrimBlob(p []byte) ([]byte, uint32, uint32, error) {
 	// Not a compliant Gzip blob. We can reject this up front.
 	// This assumes header: 10 bytes, trailer: 8 bytes.
 	if len(p) < 18 {
-		return n, ErrBlob
+		return nil, 0, 0, ErrBlob
 	}
 	trailerChecksum := binary.LittleEndian.Uint32(p[(len(p) - 8):(len(p) - 4)])
 	trailerLength := binary.LittleEndian.Uint32(p[(len(p) - 4):])
-	content, ok := getDeflateSlice(p)
-	if !ok {
-		return n, ErrBlob
+	content, err := getDeflateSlice(p)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return content, trailerChecksum, trailerLength, nil
+}
+
+// CombinedTrailer computes the CRC32 and ISIZE trailer fields that writing
+// the blobs to a GzipStreamWriter with WriteCompressed, in order, would
+// produce, without writing anything. Each blob is parsed like TrimBlob does,
+// and an invalid blob returns an error wrapping ErrBlob.
+func CombinedTrailer(blobs [][]byte) (uint32, uint32, error) {
+	var digest, size uint32
+	for i, blob := range blobs {
+		_, checksum, length, err := TrimBlob(blob)
+		if err != nil {
+			return 0, 0, fmt.Errorf("blob %d: %w", i, err)
+		}
+		digest = crc32Combine(crc32.IEEETable, digest, checksum, int(length))
+		size += length
 	}
+	return digest, size, nil
+}
 
-	z.size += trailerLength // uint32(len(p))
+// writeInflated decompresses a blob's DEFLATE payload, checks it like
+// verifyBlob, and then passes the data to Write, so that it continues the
+// current DEFLATE stream. See WithSeamlessBlobs. Nothing is written if the
+// blob is invalid. It returns the number of bytes written to the underlying
+// writer, which may be 0, since the compressor buffers its output.
+func (z *GzipStreamWriter) writeInflated(content []byte, checksum, length uint32) (int, error) {
+	decompressor := z.blobDecompressor(content)
+	defer decompressor.Close() //nolint:errcheck
 
-	z.digest = crc32Combine(z.digest, trailerChecksum, int(trailerLength))
-	n, z.err = z.w.Write(content)
+	data, err := io.ReadAll(decompressor)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrBlob, err)
+	}
+	if crc32.Checksum(data, z.crcTable) != checksum {
+		return 0, fmt.Errorf("%w: crc mismatch", ErrBlob)
+	}
+	if uint32(len(data)) != length {
+		return 0, fmt.Errorf("%w: length mismatch", ErrBlob)
+	}
 
-	// We would flush if we could here, but z.w is an io.Writer, and those do
-	// not have to implement Flush().
-	return n, z.err
+	start := z.w.n
+	if _, err := z.Write(data); err != nil {
+		return int(z.w.n - start), err
+	}
+	z.members++
+	z.memberEvent(int(z.w.n-start), checksum)
+	return int(z.w.n - start), nil
+}
+
+// blobDecompressor returns a decompressor for a blob's DEFLATE payload, which
+// uses the writer's preset dictionary, if it has one.
+func (z *GzipStreamWriter) blobDecompressor(content []byte) io.ReadCloser {
+	if z.dict != nil {
+		return flate.NewReaderDict(bytes.NewReader(content), z.dict)
+	}
+	return flate.NewReader(bytes.NewReader(content))
+}
+
+// verifyBlob decompresses a blob's DEFLATE payload, and checks that its CRC32
+// and length match the values from the blob's trailer.
+func (z *GzipStreamWriter) verifyBlob(content []byte, checksum, length uint32) error {
+	decompressor := z.blobDecompressor(content)
+	defer decompressor.Close() //nolint:errcheck
+
+	digest := crc32.New(z.crcTable)
+	decompressedLength, err := io.Copy(digest, decompressor)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBlob, err)
+	}
+	if digest.Sum32() != checksum {
+		return fmt.Errorf("%w: crc mismatch", ErrBlob)
+	}
+	if uint32(decompressedLength) != length {
+		return fmt.Errorf("%w: length mismatch", ErrBlob)
+	}
+	return nil
 }
 
 // Combine 2x CRC32 checksums into a single checksum, using the XOR method.
-func crc32Combine(front, back uint32, length int) uint32 {
+// Both checksums must have been computed with the polynomial from table.
+func crc32Combine(table *crc32.Table, front, back uint32, length int) uint32 {
 	zeroes := make([]byte, length) // HACK: Naive version.
 	// This is magic, but based on what I've been able to discern, it looks like
 	// you have to do some extra XORs to get the "front" into a form that can be
 	// XOR'd with the "back" checksum.
-	front = crc32.Update(0xffffffff^front, crc32.IEEETable, zeroes) ^ 0xffffffff
-	return front ^ back // crc32.Update(front, crc32.IEEETable, zeroes) ^ back
+	front = crc32.Update(0xffffffff^front, table, zeroes) ^ 0xffffffff
+	return front ^ back // crc32.Update(front, table, zeroes) ^ back
 }
 
-// Returns: updated slice + ok status.
-// Returns false when not a valid gzip header.
-func getDeflateSlice(gzblob []byte) ([]byte, bool) {
-	headerLength := getHeaderLength(gzblob)
-	if headerLength < 0 {
-		return nil, false
+// Returns: updated slice, or an error when not a valid gzip blob.
+func getDeflateSlice(gzblob []byte) ([]byte, error) {
+	headerLength, err := getHeaderLength(gzblob)
+	if err != nil {
+		return nil, err
 	}
 
 	// Safety.
 	if len(gzblob) < (headerLength + 8) {
-		return nil, false
+		return nil, fmt.Errorf("%w: header overruns trailer", ErrBlob)
 	}
 
-	return gzblob[headerLength:(len(gzblob) - 8)], true
+	return gzblob[headerLength:(len(gzblob) - 8)], nil
 }
 
 // Walks the state machine for determining header length, without messing around with setting state.
-// Returns a negative value on error? (Could do a boolean just as easily.)
-func getHeaderLength(gzBlob []byte) int {
-	headerLen := 10
-	if len(gzBlob) < headerLen {
-		return -1
+// Returns an error wrapping ErrBlob if the magic bytes are wrong, or wrapping
+// ErrTruncatedHeader (and ErrBlob) if a header field runs past the end of gzBlob.
+func getHeaderLength(gzBlob []byte) (int, error) {
+	// Valid header start bytes check, on as many bytes as we have.
+	magic := [3]byte{gzipID1, gzipID2, gzipDeflate}
+	if !bytes.HasPrefix(magic[:], gzBlob[:min(len(gzBlob), 3)]) {
+		return 0, fmt.Errorf("%w: bad magic bytes", ErrBlob)
 	}
 
-	// Valid header start bytes check.
-	if gzBlob[0] != gzipID1 || gzBlob[1] != gzipID2 || gzBlob[2] != gzipDeflate {
-		return -1
+	headerLen := 10
+	if len(gzBlob) < headerLen {
+		return 0, errTruncatedHeader("fixed header")
 	}
 
 	flag := gzBlob[3]
@@ -431,37 +1581,31 @@ func getHeaderLength(gzBlob []byte) int {
 		// Safety
 		headerLen += 2
 		if len(gzBlob) < headerLen {
-			return -1
+			return 0, errTruncatedHeader("extra field length")
 		}
 		extraFieldLength := binary.LittleEndian.Uint16(gzBlob[10:12])
 		// Safety
 		headerLen += int(extraFieldLength)
 		if len(gzBlob) < headerLen {
-			return -1
+			return 0, errTruncatedHeader("extra field")
 		}
 	}
 	// Scan over Name and Comment fields, which are zero-terminated.
 	if flag&flagName != 0 {
 		endField := bytes.IndexByte(gzBlob[headerLen:], byte(0))
 		if endField < 0 {
-			return -1 // Safety
-		}
-		// Safety
-		headerLen += endField
-		if len(gzBlob) < headerLen {
-			return -1
+			return 0, errTruncatedHeader("name field") // Safety
 		}
+		// Skip the field, and its NUL terminator.
+		headerLen += endField + 1
 	}
 	if flag&flagComment != 0 {
 		endField := bytes.IndexByte(gzBlob[headerLen:], byte(0))
 		if endField < 0 {
-			return -1 // Safety
-		}
-		// Safety
-		headerLen += endField
-		if len(gzBlob) < headerLen {
-			return -1
+			return 0, errTruncatedHeader("comment field") // Safety
 		}
+		// Skip the field, and its NUL terminator.
+		headerLen += endField + 1
 	}
 
 	// Scan over the Header CRC field.
@@ -469,11 +1613,16 @@ func getHeaderLength(gzBlob []byte) int {
 		// Safety
 		headerLen += 2
 		if len(gzBlob) < headerLen {
-			return -1
+			return 0, errTruncatedHeader("header CRC")
 		}
 	}
 
-	return headerLen
+	return headerLen, nil
+}
+
+// errTruncatedHeader reports a header that ends in the middle of the named field.
+func errTruncatedHeader(field string) error {
+	return fmt.Errorf("%w: %w: %s", ErrBlob, ErrTruncatedHeader, field)
 }
 
 // func (z *GzipStreamWriter) WriteTo(w io.Writer) (n int64, err error)
@@ -481,9 +1630,16 @@ func getHeaderLength(gzBlob []byte) int {
 // Close closes the [Writer] by flushing any unwritten data to the underlying
 // [io.Writer] and writing the GZIP footer.
 // It does not close the underlying [io.Writer].
+//
+// If the writer already failed with an earlier error, no trailer is written,
+// and Close returns that error wrapped, to signal that the output is incomplete.
+// After Abort, Close does nothing, and returns ErrAborted.
 func (z *GzipStreamWriter) Close() error {
+	if errors.Is(z.err, ErrAborted) {
+		return ErrAborted
+	}
 	if z.err != nil {
-		return z.err
+		return fmt.Errorf("gzip: close after error: %w", z.err)
 	}
 
 	if z.checkClosed() {
@@ -491,6 +1647,18 @@ func (z *GzipStreamWriter) Close() error {
 	}
 	z.setClosed(true)
 
+	if z.finalISIZE != nil {
+		z.size = uint64(*z.finalISIZE)
+	}
+	if z.err = z.finishMember(); z.err != nil {
+		return z.err
+	}
+	return z.flushBuffered()
+}
+
+// finishMember ends the current member's DEFLATE stream, and writes its trailer,
+// unless the writer is being closed with WithoutTrailer.
+func (z *GzipStreamWriter) finishMember() error {
 	if !z.checkWroteHeader() {
 		_, _ = z.Write(nil)
 		if z.err != nil {
@@ -498,17 +1666,360 @@ func (z *GzipStreamWriter) Close() error {
 		}
 	}
 
+	if z.err = z.flushWriteBuffer(); z.err != nil {
+		return z.err
+	}
 	if z.err = z.compressor.Close(); z.err != nil {
 		return z.err
 	}
 
-	buf := [8]byte{}
-	binary.LittleEndian.PutUint32(buf[:4], z.digest)
-	binary.LittleEndian.PutUint32(buf[4:8], z.size)
-	_, z.err = z.w.Write(buf[:8])
+	if !z.options.withoutTrailer || !z.checkClosed() {
+		buf := [12]byte{}
+		binary.LittleEndian.PutUint32(buf[:4], z.digest)
+		trailer := buf[:8]
+		if z.options.extendedTrailer {
+			binary.LittleEndian.PutUint64(buf[4:12], z.size)
+			trailer = buf[:12]
+		} else {
+			binary.LittleEndian.PutUint32(buf[4:8], uint32(z.size))
+		}
+		_, z.err = z.w.Write(trailer)
+		if z.err != nil {
+			return z.err
+		}
+	}
+	if z.aligned != nil {
+		if err := z.writeAlignedMember(); err != nil {
+			return err
+		}
+	}
+	z.memberEvent(int(z.w.n-z.memberStart), z.digest)
+	z.memberStart = z.w.n
+	return nil
+}
+
+// Abort abandons the stream without finishing it. No trailer is written, any
+// data still buffered inside the writer is discarded, and later calls to
+// Write, Close, and the other output methods return ErrAborted, until Reset.
+// Output that already reached the underlying writer is left as-is, so the
+// caller is responsible for discarding it.
+//
+// Unlike a write failure, this marks a deliberate abandonment, so that the
+// two cases can be told apart with errors.Is.
+// Calling Abort on a closed writer does nothing.
+func (z *GzipStreamWriter) Abort() error {
+	if z.checkClosed() {
+		return nil
+	}
+	z.setClosed(true)
+	z.err = ErrAborted
+	// Drop pending compressor state, without emitting it.
+	z.pending = z.pending[:0]
+	if z.compressor != nil {
+		z.compressor.Reset(io.Discard)
+	}
+	if z.aligned != nil {
+		z.aligned.member.Reset()
+	}
+	if z.buffered != nil {
+		z.buffered.Reset(io.Discard)
+	}
+	return nil
+}
+
+// SetFinalISIZE overrides the ISIZE field of the trailer written by Close,
+// which normally holds the uncompressed size of the stream (modulo 2^32).
+// The override is cleared by Reset.
+//
+// WARNING: This produces non-compliant gzip output, which standard readers
+// (including the stdlib gzip.Reader) reject with a checksum error. It only
+// exists for interop with consumers that use ISIZE as an out-of-band counter.
+func (z *GzipStreamWriter) SetFinalISIZE(isize uint32) {
+	z.finalISIZE = &isize
+}
+
+// NextMember finalizes the current gzip member, and starts a new one in the
+// same output stream. The current member's DEFLATE stream is ended, and its
+// CRC32/ISIZE trailer is written. The next write then starts the new member,
+// with a fresh copy of the header, and its own running CRC32 and size.
+//
+// Unlike Flush, which only emits a sync marker (Z_SYNC_FLUSH) inside the
+// current member, this produces a multi-member stream, which readers must
+// support (the stdlib gzip.Reader does by default).
+// Calling NextMember on a closed writer does nothing.
+func (z *GzipStreamWriter) NextMember() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.checkClosed() {
+		return nil
+	}
+
+	if z.err = z.finishMember(); z.err != nil {
+		return z.err
+	}
+	z.compressor.Reset(sinkWriter{z})
+	z.setCompressorHistory(z.dict != nil && !z.checkDroppedDict())
+	z.digest = 0
+	z.size = 0
+	z.setWroteHeader(false)
+	z.setActiveDeflateStream(false)
+	return nil
+}
+
+// CloseContext is like Close, but checks ctx for cancellation before
+// finalizing the stream. If ctx is canceled, no trailer is written, and the
+// writer is left in a terminal error state with the context's error.
+func (z *GzipStreamWriter) CloseContext(ctx context.Context) error {
+	if z.err == nil && !z.checkClosed() {
+		if z.err = ctx.Err(); z.err != nil {
+			return z.err
+		}
+	}
+	return z.Close()
+}
+
+// CloseAll is like Close, but then also closes the underlying writer, if it
+// implements io.Closer. This is for callers that own the destination, such as
+// a file that holds nothing but the gzip stream.
+//
+// If Close fails, the stream is incomplete, and its error is returned without
+// closing the underlying writer, so that the caller can decide what to do with
+// the partial output. An error from closing the underlying writer is returned
+// wrapped.
+func (z *GzipStreamWriter) CloseAll() error {
+	if err := z.Close(); err != nil {
+		return err
+	}
+	closer, ok := z.dst.(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("gzip: failed to close underlying writer: %w", err)
+	}
+	return nil
+}
+
+// Err returns the sticky error that the writer failed with, if any.
+// Once set, all later calls that produce output will return this error.
+func (z *GzipStreamWriter) Err() error {
 	return z.err
 }
 
+// Validate checks that the writer can still be written to, for tests and
+// debug assertions after a sequence of operations. It returns the sticky
+// error, wrapped, if the writer failed (or ErrAborted, if it was aborted), and
+// ErrClosed if it was closed. Otherwise, it checks the writer's internal
+// invariants, such as having an underlying writer, and a compressor once the
+// header is written, and returns an error wrapping ErrInvalidState describing
+// the first one that does not hold. It does not change the writer.
+func (z *GzipStreamWriter) Validate() error {
+	switch {
+	case errors.Is(z.err, ErrAborted):
+		return ErrAborted
+	case z.err != nil:
+		return fmt.Errorf("gzip: writer failed: %w", z.err)
+	case z.checkClosed():
+		return ErrClosed
+	case z.dst == nil:
+		return fmt.Errorf("%w: no underlying writer", ErrInvalidState)
+	case z.checkWroteHeader() && z.compressor == nil:
+		return fmt.Errorf("%w: header written without a compressor", ErrInvalidState)
+	case z.checkActiveDeflateStream() && !z.checkWroteHeader():
+		return fmt.Errorf("%w: deflate stream active before the header", ErrInvalidState)
+	case len(z.pending) > max(z.options.writeBuffer, 0):
+		return fmt.Errorf("%w: %d bytes pending, write buffer holds %d", ErrInvalidState, len(z.pending), z.options.writeBuffer)
+	case (z.aligned != nil) != (z.options.memberAlignment > 0):
+		return fmt.Errorf("%w: member alignment does not match its output buffer", ErrInvalidState)
+	}
+	return nil
+}
+
+// Digest returns the running CRC32 and size (modulo 2^32) of the uncompressed
+// data in the current member, as they would be written in its trailer.
+// Together with the output written so far, they can be saved, and passed to
+// ResumeDigest to continue the stream later. After Close, they are the values
+// of the last trailer, including the one left out with WithoutTrailer.
+func (z *GzipStreamWriter) Digest() (uint32, uint32) {
+	return crc32.Update(z.digest, z.crcTable, z.pending), uint32(z.size)
+}
+
+// ResetDigest zeroes the running CRC32 and size of the current member, so
+// that the trailer eventually written only covers the data written after
+// this call. The header, the compressor, and the output written so far are
+// left alone. This is for custom framings that keep their own checksums for
+// segments of a member, such as with WithoutTrailer: read the segment's
+// values with Digest, then call ResetDigest to start the next segment.
+//
+// WARNING: Unless the trailer is left out, or replaced by the caller, this
+// produces non-compliant gzip output, which standard readers (including the
+// stdlib gzip.Reader) reject with a checksum error.
+func (z *GzipStreamWriter) ResetDigest() error {
+	if z.err != nil {
+		return z.err
+	}
+	// Data held back by WithWriteBuffer belongs to the old segment.
+	if err := z.flushWriteBuffer(); err != nil {
+		return err
+	}
+	z.digest = 0
+	z.size = 0
+	return nil
+}
+
+// ResumeDigest sets up a fresh writer to continue a stream that an earlier
+// writer started, for example before a process restart. The running CRC32 and
+// size are seeded with the values from Digest, and the header is treated as
+// already written, so that the writer's output can be appended directly to
+// the earlier output. If the header was already written, ErrHeaderAlreadyWritten
+// is returned, and nothing changes.
+//
+// A DEFLATE stream cannot be resumed, so this is only valid if the earlier
+// writer's output ended on a blob boundary, right after WriteCompressed (or
+// WriteCompressedReader), and the stream is continued with pre-compressed
+// blobs. Resuming after Write leaves the earlier compressor's pending data
+// behind, and produces a corrupt stream.
+func (z *GzipStreamWriter) ResumeDigest(checksum, size uint32) error {
+	if z.checkWroteHeader() {
+		return ErrHeaderAlreadyWritten
+	}
+	z.digest = checksum
+	z.size = uint64(size)
+	z.setWroteHeader(true)
+	z.initCompressor()
+	return nil
+}
+
+// EstimatedSize returns the size the output will have once the writer is
+// closed: the bytes written to the underlying writer so far, plus whatever
+// Close would still add (the header if not yet written, the end of the DEFLATE
+// stream, the 8-byte trailer, and any WithMemberAlignment padding). After
+// Close, it is the exact output size.
+//
+// The estimate is exact when no DEFLATE stream is active, such as for a
+// stream built entirely from WriteCompressed blobs, or right after Flush.
+// Otherwise, it is approximate, since data buffered inside the compressor
+// has not been emitted yet, and its compressed size is unknown.
+func (z *GzipStreamWriter) EstimatedSize() int64 {
+	if z.checkClosed() {
+		return z.w.n
+	}
+	size := z.w.n + int64(z.trailerSize()) + int64(emptyFinalBlockSize(z.compressorLevel))
+	if z.options.withoutTrailer {
+		size -= int64(z.trailerSize())
+	}
+	if !z.checkWroteHeader() {
+		size += int64(z.headerSize())
+	}
+	return size + z.alignmentPadding(size-z.w.n)
+}
+
+// trailerSize returns the length of the trailers that finishMember writes.
+func (z *GzipStreamWriter) trailerSize() int {
+	if z.options.extendedTrailer {
+		return 12
+	}
+	return 8
+}
+
+// headerSize returns the length of the header that writeHeader would write.
+func (z *GzipStreamWriter) headerSize() int {
+	size := 10
+	if z.Extra != nil {
+		size += 2 + len(z.Extra)
+	}
+	// Header strings are written as Latin-1, one byte per rune.
+	if z.Name != "" {
+		size += utf8.RuneCountInString(z.Name) + 1
+	}
+	if z.Comment != "" {
+		size += utf8.RuneCountInString(z.Comment) + 1
+	}
+	return size
+}
+
+// emptyFinalBlocks records the bytes flate.Writer.Close emits for each
+// compression level, when there is no pending input to compress.
+// This depends on the flate implementation, so it is measured once, on first
+// use, rather than hardcoded.
+var emptyFinalBlocks = sync.OnceValue(func() [BestCompression - HuffmanOnly + 1][]byte {
+	var blocks [BestCompression - HuffmanOnly + 1][]byte
+	for i := range blocks {
+		var buf bytes.Buffer
+		compressor, _ := flate.NewWriter(&buf, i+HuffmanOnly)
+		_ = compressor.Close()
+		blocks[i] = buf.Bytes()
+	}
+	return blocks
+})
+
+func emptyFinalBlockFor(level int) []byte {
+	return emptyFinalBlocks()[level-HuffmanOnly]
+}
+
+func emptyFinalBlockSize(level int) int {
+	return len(emptyFinalBlockFor(level))
+}
+
+// String describes the internal state of the writer, for debugging.
+// It is safe to call on a nil or zero-value writer.
+func (z *GzipStreamWriter) String() string {
+	if z == nil {
+		return "GzipStreamWriter(nil)"
+	}
+	buf := make([]byte, 0, 128)
+	buf = append(buf, "GzipStreamWriter{level: "...)
+	buf = strconv.AppendInt(buf, int64(z.level), 10)
+	buf = append(buf, ", wroteHeader: "...)
+	buf = strconv.AppendBool(buf, z.checkWroteHeader())
+	buf = append(buf, ", closed: "...)
+	buf = strconv.AppendBool(buf, z.checkClosed())
+	buf = append(buf, ", activeDeflateStream: "...)
+	buf = strconv.AppendBool(buf, z.checkActiveDeflateStream())
+	buf = append(buf, ", size: "...)
+	buf = strconv.AppendUint(buf, z.size, 10)
+	buf = append(buf, ", digest: 0x"...)
+	// Pad the digest, so that it always shows all 8 hex digits.
+	var digestBuf [8]byte
+	checksum, _ := z.Digest()
+	digest := strconv.AppendUint(digestBuf[:0], uint64(checksum), 16)
+	for range 8 - len(digest) {
+		buf = append(buf, '0')
+	}
+	buf = append(buf, digest...)
+	buf = append(buf, '}')
+	return string(buf)
+}
+
+// HeaderWritten reports whether the gzip header for the current member has
+// been written. The embedded gzip.Header fields may only be changed before
+// this happens.
+func (z *GzipStreamWriter) HeaderWritten() bool {
+	return z.checkWroteHeader()
+}
+
+// Closed reports whether the writer has been closed.
+func (z *GzipStreamWriter) Closed() bool {
+	return z.checkClosed()
+}
+
+// InDeflateStream reports whether data from Write is pending in the
+// compressor's DEFLATE stream. If so, the next WriteCompressed (or Flush)
+// has to flush the compressor first, and emit a sync marker. Batching all
+// WriteCompressed calls before any Write calls avoids those flushes.
+func (z *GzipStreamWriter) InDeflateStream() bool {
+	return z.checkActiveDeflateStream()
+}
+
+// OutputBytes returns the number of bytes written to the underlying writer
+// so far, across all members. Data still buffered inside the compressor is
+// not counted until it is flushed. The count carries over SetWriter, and
+// starts over on Reset.
+func (z *GzipStreamWriter) OutputBytes() int64 {
+	return z.w.n
+}
+
 // Flush flushes any pending compressed data to the underlying writer.
 //
 // It is useful mainly in compressed network protocols, to ensure that
@@ -527,15 +2038,114 @@ func (z *GzipStreamWriter) Flush() error {
 	}
 
 	if !z.checkWroteHeader() {
+		if z.options.lazyHeaderOnFlush {
+			return z.flushBuffered()
+		}
 		if _, err := z.Write(nil); err != nil {
 			return z.err
 		}
 	}
-	z.err = z.compressor.Flush()
+	if z.err = z.flushCompressor(); z.err != nil {
+		return z.err
+	}
 	z.setActiveDeflateStream(false)
+	return z.flushBuffered()
+}
+
+// FlushN is like Flush, but also returns the number of bytes that the flush
+// wrote to the underlying writer: any compressed data that was buffered
+// inside the compressor, the sync marker, and the header, if it had not been
+// written yet. This is the change in OutputBytes.
+//
+// A flush with no pending data returns 0. Unlike Flush, it does not emit an
+// extra sync marker in that case, since there is nothing to delimit.
+func (z *GzipStreamWriter) FlushN() (int64, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.checkWroteHeader() && !z.checkActiveDeflateStream() {
+		return 0, z.flushBuffered()
+	}
+	before := z.w.n
+	err := z.Flush()
+	return z.w.n - before, err
+}
+
+// flushBuffered flushes the output buffer through to the destination writer,
+// if the writer has one.
+func (z *GzipStreamWriter) flushBuffered() error {
+	if z.buffered != nil {
+		z.err = z.buffered.Flush()
+	}
 	return z.err
 }
 
+// FlushMember finalizes the current gzip member and flushes it to the
+// underlying writer, so that all output so far forms a complete gzip stream.
+// The next write starts a new member, as with NextMember.
+//
+// This is intended for crash-recoverable output, such as logs: if the output
+// is later cut off mid-member, a multistream reader can still recover all of
+// the data up to the last completed FlushMember call.
+// By contrast, Flush only emits a DEFLATE sync marker (Z_SYNC_FLUSH) inside
+// the current member. That makes the data so far decodable by a streaming
+// reader, but the member has no trailer until Close, so truncated output
+// fails the reader's checksum validation.
+//
+// If nothing has been written since the last member boundary, no empty member
+// is emitted, and FlushMember only flushes any buffered output.
+func (z *GzipStreamWriter) FlushMember() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.checkClosed() {
+		return nil
+	}
+
+	if z.checkWroteHeader() {
+		if err := z.NextMember(); err != nil {
+			return err
+		}
+	}
+	return z.flushBuffered()
+}
+
+// SetWriter redirects all further output to w, while continuing the same gzip
+// stream: the header is not written again, and the running CRC32 and size
+// carry over into the trailer eventually written by Close.
+//
+// The compressor buffers data internally, so any pending compressed data is
+// flushed to the current writer before switching, as if by Flush. Each writer
+// thus receives a contiguous piece of the stream, ending at a DEFLATE sync
+// point, and the pieces can be concatenated back together in order.
+func (z *GzipStreamWriter) SetWriter(w io.Writer) error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.checkActiveDeflateStream() {
+		if z.err = z.flushCompressor(); z.err != nil {
+			return z.err
+		}
+		z.setActiveDeflateStream(false)
+	}
+	z.dst = w
+	w = z.destination(w)
+	if z.buffered != nil {
+		if z.err = z.buffered.Flush(); z.err != nil {
+			return z.err
+		}
+		z.buffered.Reset(w)
+		return nil
+	}
+	if z.aligned != nil {
+		// The current member is written to w once it is finished.
+		z.aligned.dst = w
+		return nil
+	}
+	z.w.w = w
+	return nil
+}
+
 // Reset resets the GzipStreamWriter's compressor and other internal state, and changes the output destination to the provided io.Writer.
 func (z *GzipStreamWriter) Reset(w io.Writer) {
 	z.init(w, z.level)
@@ -544,6 +2154,33 @@ func (z *GzipStreamWriter) Reset(w io.Writer) {
 	z.setActiveDeflateStream(false)
 }
 
+// ResetWithHeader is like Reset, but also replaces the header with h, for
+// reusing a writer across streams that each need different metadata.
+// Note that h is applied as given, so a zero OS field means FAT, rather than
+// the "unknown" (255) that Reset uses.
+//
+// The header strings are validated before anything is reset. If they are not
+// valid Latin-1, ErrHdrNonLatin1 is returned, and the writer is left unchanged.
+func (z *GzipStreamWriter) ResetWithHeader(w io.Writer, h gzip.Header) error {
+	if err := validateHeader(h); err != nil {
+		return err
+	}
+	z.Reset(w)
+	z.Header = h
+	return nil
+}
+
+// ResetKeepHeader is like Reset, but keeps the current header, including the
+// backing arrays of its Extra field, instead of starting over with an empty
+// one. For pooled writers whose streams all carry the same metadata, this
+// saves rebuilding the header, and reallocating its Extra subfields, on every
+// cycle. The header is written again at the start of the next stream.
+func (z *GzipStreamWriter) ResetKeepHeader(w io.Writer) {
+	header := z.Header
+	z.Reset(w)
+	z.Header = header
+}
+
 // Assertions for checking that we implemented the interfaces.
 // The compiler will optimize all of these away.
 var (
@@ -2,8 +2,19 @@ package gzipstreamwriter
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"hash/crc32"
+	"io"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
 	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 // Originally generated by Copilot, and modified to be an actual test.
@@ -13,14 +24,422 @@ func FuzzCRC32Combine(f *testing.F) {
 	f.Add([]byte{0x12, 0x34, 0x56, 0x78}, []byte{0x9a, 0xbc, 0xde, 0xf0})
 	f.Add(bytes.Repeat([]byte{0x12, 0x34, 0x56, 0x78}, 16), bytes.Repeat([]byte{0x9a, 0xbc, 0xde, 0xf0}, 16))
 
+	tables := []*crc32.Table{crc32.IEEETable, crc32.MakeTable(crc32.Castagnoli)}
+
 	f.Fuzz(func(t *testing.T, frontBytes []byte, backBytes []byte) {
-		frontCRC := crc32.ChecksumIEEE(frontBytes)
-		backCRC := crc32.ChecksumIEEE(backBytes)
-		expectedCRC := crc32.ChecksumIEEE(append(frontBytes, backBytes...))
-		length := len(backBytes)
+		for _, table := range tables {
+			frontCRC := crc32.Checksum(frontBytes, table)
+			backCRC := crc32.Checksum(backBytes, table)
+			expectedCRC := crc32.Checksum(append(frontBytes, backBytes...), table)
+			length := len(backBytes)
+
+			if resultCRC := crc32Combine(table, frontCRC, backCRC, length); resultCRC != expectedCRC {
+				t.Errorf("expected CRC: %d, got CRC: %d", expectedCRC, resultCRC)
+			}
+		}
+	})
+}
+
+func TestScanDeflate(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		nil,
+		[]byte("A"),
+		bytes.Repeat([]byte("ABCD"), 1000),
+		randomBytes(t, 70000),
+		append(bytes.Repeat([]byte("The quick brown fox. "), 500), randomBytes(t, 5000)...),
+	}
+	levels := []int{HuffmanOnly, DefaultCompression, NoCompression, BestSpeed, 5, BestCompression}
+
+	for _, input := range inputs {
+		for _, level := range levels {
+			var buf bytes.Buffer
+			w, err := flate.NewWriter(&buf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Mix sync flushes in with regular blocks.
+			half := len(input) / 2
+			_, _ = w.Write(input[:half])
+			_ = w.Flush()
+			_, _ = w.Write(input[half:])
+			_ = w.Close()
+			stream := buf.Bytes()
+
+			// Trailing bytes after the stream must be left alone.
+			scan, err := scanDeflate(append(slices.Clone(stream), 0xde, 0xad))
+			if err != nil {
+				t.Fatalf("level %d, len %d: expected no error, got %v", level, len(input), err)
+			}
+			if scan.length != len(stream) {
+				t.Fatalf("level %d, len %d: expected length %d, got %d", level, len(input), len(stream), scan.length)
+			}
+			if scan.finalBlockEnd > scan.length*8 || scan.finalBlockBit >= scan.finalBlockEnd {
+				t.Fatalf("level %d, len %d: bad final block bounds %+v", level, len(input), scan)
+			}
+			if scan.hasData != (len(input) > 0) {
+				t.Fatalf("level %d, len %d: expected hasData %t, got %t", level, len(input), len(input) > 0, scan.hasData)
+			}
+
+			// Any truncation must be detected.
+			if _, err := scanDeflate(stream[:len(stream)-1]); !errors.Is(err, ErrBlob) {
+				t.Fatalf("level %d, len %d: expected error %v, got %v", level, len(input), ErrBlob, err)
+			}
+		}
+	}
+}
+
+func TestSpliceDeflate(t *testing.T) {
+	t.Parallel()
+
+	type testcase struct {
+		note   string
+		input  []byte
+		stream []byte
+	}
+	var testcases []testcase
+
+	inputs := [][]byte{
+		nil,
+		bytes.Repeat([]byte("ABCD"), 1000),
+		randomBytes(t, 70000),
+	}
+	for n := range 40 {
+		inputs = append(inputs, randomBytes(t, n))
+	}
+	levels := []int{HuffmanOnly, DefaultCompression, NoCompression, BestSpeed, BestCompression}
+	for _, input := range inputs {
+		for _, level := range levels {
+			var buf bytes.Buffer
+			w, err := flate.NewWriter(&buf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.Write(input)
+			_ = w.Close()
+			testcases = append(testcases, testcase{
+				note:   fmt.Sprintf("level %d, len %d", level, len(input)),
+				input:  input,
+				stream: buf.Bytes(),
+			})
+		}
+	}
+
+	// Which bit offsets the encoder's final blocks end at depends on the Go
+	// version, so hand-built streams cover every offset. Each 9-bit literal
+	// moves the end of a fixed Huffman block along by one bit.
+	for n := range 8 {
+		literals := bytes.Repeat([]byte{0xf0}, n)
+		testcases = append(testcases, testcase{
+			note:   fmt.Sprintf("fixed final block, %d literals", n),
+			input:  literals,
+			stream: fixedBlocks(literals, nil),
+		}, testcase{
+			note:   fmt.Sprintf("stored final block, %d literals", n),
+			input:  slices.Concat(literals, []byte("stored")),
+			stream: fixedBlocks(literals, []byte("stored")),
+		})
+	}
+
+	follow := []byte("data after the spliced stream")
+	var endOffsets [8]bool
+	for _, tc := range testcases {
+		original := slices.Clone(tc.stream)
+
+		scan, err := scanDeflate(tc.stream)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.note, err)
+		}
+		endOffsets[scan.finalBlockEnd%8] = true
+
+		var spliceBuf [spliceTailSize + 1]byte
+		var spliced []byte
+		for _, piece := range spliceDeflate(&spliceBuf, tc.stream, scan) {
+			spliced = append(spliced, piece...)
+		}
+		if !bytes.Equal(original, tc.stream) {
+			t.Fatalf("%s: expected input to be unmodified", tc.note)
+		}
+
+		// The streaming version must produce the same bytes, even when
+		// reading a byte at a time.
+		var streamed bytes.Buffer
+		streamScan, err := spliceDeflateStream(&streamed, iotest.OneByteReader(bytes.NewReader(tc.stream)))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.note, err)
+		}
+		if streamScan != scan {
+			t.Fatalf("%s: expected scan %+v, got %+v", tc.note, scan, streamScan)
+		}
+		if diff := cmp.Diff(spliced, streamed.Bytes()); diff != "" {
+			t.Fatalf("%s: spliceDeflateStream() mismatch (-want +got):\n%s", tc.note, diff)
+		}
+
+		// A spliced stream must let another stream follow it.
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, DefaultCompression)
+		_, _ = w.Write(follow)
+		_ = w.Close()
+		combined := append(spliced, buf.Bytes()...)
+		result, err := io.ReadAll(flate.NewReader(bytes.NewReader(combined)))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.note, err)
+		}
+		if exp := slices.Concat(tc.input, follow); !bytes.Equal(exp, result) {
+			t.Fatalf("%s: expected %d bytes of output, got %d bytes", tc.note, len(exp), len(result))
+		}
+	}
+
+	for offset, seen := range endOffsets {
+		if !seen {
+			t.Fatalf("expected a final block ending at bit offset %d", offset)
+		}
+	}
+}
+
+// fixedBlocks hand-encodes a DEFLATE stream holding literals, which must all
+// be in the range 144-255, in a fixed Huffman block. If stored is nil, that
+// block is the final one. Otherwise, a final stored block holding stored
+// follows it.
+func fixedBlocks(literals, stored []byte) []byte {
+	var out []byte
+	var acc uint64
+	var nbits uint
+	write := func(value uint64, n uint) {
+		acc |= value << nbits
+		nbits += n
+		for nbits >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			nbits -= 8
+		}
+	}
+	// Huffman codes are packed starting from their most significant bit.
+	writeCode := func(code uint64, n uint) {
+		write(bits.Reverse64(code)>>(64-n), n)
+	}
+
+	final := uint64(0)
+	if stored == nil {
+		final = 1
+	}
+	write(final, 1)
+	write(1, 2) // BTYPE 01, fixed Huffman codes.
+	for _, b := range literals {
+		writeCode(0x190+uint64(b-144), 9)
+	}
+	writeCode(0, 7) // End of block.
+	if stored != nil {
+		write(1, 1)
+		write(0, 2) // BTYPE 00, stored.
+		if nbits > 0 {
+			write(0, 8-nbits)
+		}
+		write(uint64(len(stored)), 16)
+		write(uint64(^uint16(len(stored))), 16)
+		out = append(out, stored...)
+	}
+	if nbits > 0 {
+		out = append(out, byte(acc))
+	}
+	return out
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	r := rand.New(rand.NewPCG(uint64(n), 0))
+	for i := range b {
+		b[i] = byte(r.Uint32())
+	}
+	return b
+}
+
+func TestGetDeflateSliceHeaderStrings(t *testing.T) {
+	t.Parallel()
+
+	input := bytes.Repeat([]byte("hello, world! "), 50)
+	var expDeflate bytes.Buffer
+	compressor, _ := flate.NewWriter(&expDeflate, DefaultCompression)
+	_, _ = compressor.Write(input)
+	_ = compressor.Close()
+
+	testcases := []struct {
+		note   string
+		header gzip.Header
+	}{
+		{
+			note:   "no header strings",
+			header: gzip.Header{},
+		},
+		{
+			note:   "name only",
+			header: gzip.Header{Name: "events.json"},
+		},
+		{
+			note:   "comment only",
+			header: gzip.Header{Comment: "a comment"},
+		},
+		{
+			note:   "name and comment",
+			header: gzip.Header{Name: "events.json", Comment: "a comment"},
+		},
+		{
+			note:   "extra, name, and comment",
+			header: gzip.Header{Extra: []byte{'A', 'B', 2, 0, 1, 2}, Name: "x", Comment: "y"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Header = tc.header
+			_, _ = w.Write(input)
+			_ = w.Close()
+
+			deflate, err := getDeflateSlice(buf.Bytes())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(expDeflate.Bytes(), deflate) {
+				t.Fatalf("expected deflate slice %x, got %x", expDeflate.Bytes(), deflate)
+			}
+		})
+	}
+}
+
+func TestGetHeaderLengthErrors(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note      string
+		header    []byte
+		length    int
+		truncated bool
+		err       error
+	}{
+		{
+			note:   "minimal header",
+			header: []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255},
+			length: 10,
+		},
+		{
+			note:   "bad magic bytes",
+			header: []byte{0x1f, 0x8c, 8, 0, 0, 0, 0, 0, 0, 255},
+			err:    ErrBlob,
+		},
+		{
+			note:   "not deflate",
+			header: []byte{0x1f, 0x8b, 7, 0, 0, 0, 0, 0, 0, 255},
+			err:    ErrBlob,
+		},
+		{
+			note:      "truncated fixed header",
+			header:    []byte{0x1f, 0x8b, 8, 0},
+			truncated: true,
+			err:       ErrBlob,
+		},
+		{
+			note:      "truncated extra field length",
+			header:    []byte{0x1f, 0x8b, 8, flagExtra, 0, 0, 0, 0, 0, 255, 4},
+			truncated: true,
+			err:       ErrBlob,
+		},
+		{
+			note:      "extra field overruns buffer",
+			header:    []byte{0x1f, 0x8b, 8, flagExtra, 0, 0, 0, 0, 0, 255, 0xff, 0xff, 'A', 'B'},
+			truncated: true,
+			err:       ErrBlob,
+		},
+		{
+			note:      "unterminated name",
+			header:    []byte{0x1f, 0x8b, 8, flagName, 0, 0, 0, 0, 0, 255, 'a', 'b'},
+			truncated: true,
+			err:       ErrBlob,
+		},
+		{
+			note:   "name, comment, and header CRC",
+			header: []byte{0x1f, 0x8b, 8, flagName | flagComment | flagHdrCrc, 0, 0, 0, 0, 0, 255, 'a', 0, 'b', 0, 0x12, 0x34},
+			length: 16,
+		},
+		{
+			note:      "truncated header CRC",
+			header:    []byte{0x1f, 0x8b, 8, flagHdrCrc, 0, 0, 0, 0, 0, 255, 0x12},
+			truncated: true,
+			err:       ErrBlob,
+		},
+		{
+			note:   "reserved flag bits are ignored",
+			header: []byte{0x1f, 0x8b, 8, 0xe0, 0, 0, 0, 0, 0, 255},
+			length: 10,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			length, err := getHeaderLength(tc.header)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if errors.Is(err, ErrTruncatedHeader) != tc.truncated {
+				t.Fatalf("expected truncated header: %v, got error %v", tc.truncated, err)
+			}
+			if length != tc.length {
+				t.Fatalf("expected header length %d, got %d", tc.length, length)
+			}
+		})
+	}
+}
+
+func FuzzGetHeaderLength(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255})
+	f.Add([]byte{0x1f, 0x8b, 8, flagExtra, 0, 0, 0, 0, 0, 255, 0xff, 0xff, 'A', 'B'})
+	f.Add([]byte{0x1f, 0x8b, 8, flagName | flagComment | flagHdrCrc, 0, 0, 0, 0, 0, 255, 'a', 0, 'b', 0, 0x12, 0x34})
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	gzWriter.Name = "name"
+	gzWriter.Comment = "comment"
+	gzWriter.Extra = []byte{'A', 'B', 0, 0}
+	if _, err := gzWriter.Write([]byte("hello, world!\n")); err != nil {
+		f.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, err := getHeaderLength(data)
+		if err != nil {
+			if !errors.Is(err, ErrBlob) {
+				t.Fatalf("expected ErrBlob, got %v", err)
+			}
+			if length != 0 {
+				t.Fatalf("expected header length 0 on error, got %d", length)
+			}
+		} else {
+			if length < 10 || length > len(data) {
+				t.Fatalf("expected header length in [10, %d], got %d", len(data), length)
+			}
+			// The header alone parses the same, and any less of it is truncated.
+			if headerOnly, err := getHeaderLength(data[:length]); err != nil || headerOnly != length {
+				t.Fatalf("expected header length %d for the header alone, got %d (error %v)", length, headerOnly, err)
+			}
+			if _, err := getHeaderLength(data[:length-1]); !errors.Is(err, ErrTruncatedHeader) {
+				t.Fatalf("expected ErrTruncatedHeader for a cut off header, got %v", err)
+			}
+		}
 
-		if resultCRC := crc32Combine(frontCRC, backCRC, length); resultCRC != expectedCRC {
-			t.Errorf("expected CRC: %d, got CRC: %d", expectedCRC, resultCRC)
+		deflate, err := getDeflateSlice(data)
+		if err == nil && len(deflate) != len(data)-length-8 {
+			t.Fatalf("expected deflate slice of %d bytes, got %d", len(data)-length-8, len(deflate))
 		}
 	})
 }
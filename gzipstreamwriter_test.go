@@ -2,12 +2,23 @@ package gzipstreamwriter_test
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"math/rand/v2"
+	"os"
+	"runtime"
 	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/philipaconrad/gzipstreamwriter"
@@ -222,10 +233,3755 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWriteContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("live context is same as Write", func(t *testing.T) {
+		t.Parallel()
+
+		input := bytes.Repeat([]byte("ABCDEFGH"), 20000) // Several chunks.
+		expBuffer := bytes.Buffer{}
+		actBuffer := bytes.Buffer{}
+
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		n, err := actGzipWriter.WriteContext(context.Background(), input)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n != len(input) {
+			t.Fatalf("expected %d bytes written, got %d bytes", len(input), n)
+		}
+		if err := actGzipWriter.CloseContext(context.Background()); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWriteContext() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("canceled context is sticky", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		if _, err := actGzipWriter.WriteContext(ctx, []byte("hello")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		cancel()
+
+		if _, err := actGzipWriter.WriteContext(ctx, []byte("world")); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v", context.Canceled, err)
+		}
+		if _, err := actGzipWriter.Write([]byte("world")); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v", context.Canceled, err)
+		}
+		if err := actGzipWriter.Close(); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v", context.Canceled, err)
+		}
+	})
+
+	t.Run("canceled context skips trailer", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		if err := actGzipWriter.CloseContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error %v, got %v", context.Canceled, err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+		}
+	})
+}
+
+func TestNewGzipStreamWriterDict(t *testing.T) {
+	t.Parallel()
+
+	dict := []byte(`{"event":"page_view","user_agent":"Mozilla/5.0","status":200}`)
+	input := []byte(`{"event":"page_view","user_agent":"Mozilla/5.0","status":404}`)
+
+	t.Run("round-trips with the same dictionary", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterDict(&actBuffer, gzipstreamwriter.BestCompression, dict)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		result := decompressDeflateDict(t, actBuffer.Bytes(), dict)
+		if diff := cmp.Diff(input, result); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterDict() mismatch (-want +got):\n%s", diff)
+		}
+
+		// The dictionary should be doing real work on such similar data.
+		plainBuffer := bytes.Buffer{}
+		plainGzipWriter, _ := gzipstreamwriter.NewGzipStreamWriterLevel(&plainBuffer, gzipstreamwriter.BestCompression)
+		if _, err := writeToBuffer(t, plainGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+		if actBuffer.Len() >= plainBuffer.Len() {
+			t.Fatalf("expected dictionary output (%d bytes) to be smaller than plain output (%d bytes)", actBuffer.Len(), plainBuffer.Len())
+		}
+	})
+
+	t.Run("dictionary survives Reset", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterDict(io.Discard, gzipstreamwriter.DefaultCompression, dict)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+		actGzipWriter.Reset(&actBuffer)
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		result := decompressDeflateDict(t, actBuffer.Bytes(), dict)
+		if diff := cmp.Diff(input, result); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterDict() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewGzipStreamWriterDict(io.Discard, 42, dict); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrInvalidCompressionLevel, err)
+		}
+	})
+}
+
+func TestNewGzipStreamWriterLevelDict(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note  string
+		level int
+		dict  []byte
+		err   error
+	}{
+		{
+			note:  "nil dictionary",
+			level: gzipstreamwriter.DefaultCompression,
+			dict:  nil,
+		},
+		{
+			note:  "full window dictionary",
+			level: gzipstreamwriter.BestSpeed,
+			dict:  bytes.Repeat([]byte("A"), 32*1024),
+		},
+		{
+			note:  "oversized dictionary",
+			level: gzipstreamwriter.BestSpeed,
+			dict:  bytes.Repeat([]byte("A"), 32*1024+1),
+			err:   gzipstreamwriter.ErrDictTooLarge,
+		},
+		{
+			note:  "invalid level",
+			level: gzipstreamwriter.HuffmanOnly - 1,
+			dict:  []byte("A"),
+			err:   gzipstreamwriter.ErrInvalidCompressionLevel,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := gzipstreamwriter.NewGzipStreamWriterLevelDict(io.Discard, tc.level, tc.dict)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			_, err = gzipstreamwriter.NewGzipStreamWriterDict(io.Discard, tc.level, tc.dict)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestErr(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fresh writer has no error", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if err := actGzipWriter.Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("close after error wraps sticky error", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&failingWriter{})
+		if _, err := actGzipWriter.Write([]byte("hello")); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if err := actGzipWriter.Err(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+
+		err := actGzipWriter.Close()
+		if !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if !strings.HasPrefix(err.Error(), "gzip: close after error: ") {
+			t.Fatalf("expected close-after-error message, got %q", err.Error())
+		}
+	})
+}
+
+func TestVerifyBlobs(t *testing.T) {
+	t.Parallel()
+
+	input := bytes.Repeat([]byte("hello, world! "), 100)
+	blob := compressStdlib(t, input)
+	badChecksum := slices.Clone(blob)
+	badChecksum[len(badChecksum)-8] ^= 0xff
+	badLength := slices.Clone(blob)
+	badLength[len(badLength)-4] ^= 0xff
+	badDeflate := slices.Clone(blob)
+	badDeflate[10] = 0xff // Invalid block type.
+
+	testcases := []struct {
+		note   string
+		verify bool
+		blob   []byte
+		err    error
+		detail string
+	}{
+		{
+			note:   "valid blob",
+			verify: true,
+			blob:   blob,
+		},
+		{
+			note:   "bad checksum, not verified",
+			verify: false,
+			blob:   badChecksum,
+		},
+		{
+			note:   "bad checksum",
+			verify: true,
+			blob:   badChecksum,
+			err:    gzipstreamwriter.ErrBlob,
+			detail: "crc mismatch",
+		},
+		{
+			note:   "bad length",
+			verify: true,
+			blob:   badLength,
+			err:    gzipstreamwriter.ErrBlob,
+			detail: "length mismatch",
+		},
+		{
+			note:   "bad deflate payload",
+			verify: true,
+			blob:   badDeflate,
+			err:    gzipstreamwriter.ErrBlob,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.VerifyBlobs(tc.verify))
+			_, err := actGzipWriter.WriteCompressed(tc.blob)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.detail) {
+				t.Fatalf("expected error to mention %q, got %q", tc.detail, err.Error())
+			}
+		})
+	}
+}
+
+func TestSetWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pieces concatenate into one stream", func(t *testing.T) {
+		t.Parallel()
+
+		inputs := [][]byte{
+			[]byte("hello, "),
+			bytes.Repeat([]byte("hello, "), 1000),
+			[]byte("world!"),
+		}
+		pieces := make([]bytes.Buffer, len(inputs))
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&pieces[0])
+		for i, input := range inputs {
+			if err := actGzipWriter.SetWriter(&pieces[i]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.Write(input); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the first piece gets the header.
+		if pieces[1].Bytes()[0] == 0x1f {
+			t.Fatalf("expected no header in second piece, got %x", pieces[1].Bytes()[:10])
+		}
+
+		var stream []byte
+		for i := range pieces {
+			stream = append(stream, pieces[i].Bytes()...)
+		}
+		result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(slices.Concat(inputs...), result); diff != "" {
+			t.Fatalf("TestSetWriter() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("flush error is sticky", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&failingWriter{okWrites: 1})
+		if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.SetWriter(io.Discard); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if err := actGzipWriter.Err(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+	})
+}
+
+func TestNewGzipStreamWriterBuffered(t *testing.T) {
+	t.Parallel()
+
+	t.Run("output is same as unbuffered", func(t *testing.T) {
+		t.Parallel()
+
+		input := bytes.Repeat([]byte("hello, world! "), 1000)
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		expGzipWriter.Name = "events.json"
+		if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		actBuffer := countingWriteCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&actBuffer)
+		actGzipWriter.Name = "events.json"
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterBuffered() mismatch (-want +got):\n%s", diff)
+		}
+		if actBuffer.closes != 0 {
+			t.Fatalf("expected underlying writer to stay open, got %d Close calls", actBuffer.closes)
+		}
+	})
+
+	t.Run("flush reaches underlying writer", func(t *testing.T) {
+		t.Parallel()
+
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		actBuffer := countingWriteCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&actBuffer)
+		for _, z := range []*gzipstreamwriter.GzipStreamWriter{expGzipWriter, actGzipWriter} {
+			if _, err := z.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := z.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterBuffered() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("buffering survives Reset", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := countingWriteCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(io.Discard)
+		actGzipWriter.Reset(&actBuffer)
+		for range 100 {
+			if _, err := actGzipWriter.Write([]byte("A")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if actBuffer.writes != 1 {
+			t.Fatalf("expected a single buffered write, got %d writes", actBuffer.writes)
+		}
+	})
+
+	t.Run("close delivers the trailer", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := countingWriteCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&actBuffer)
+		if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if actBuffer.writes != 0 {
+			t.Fatalf("expected output to be buffered, got %d writes", actBuffer.writes)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if int64(actBuffer.Len()) != actGzipWriter.OutputBytes() {
+			t.Fatalf("expected %d bytes written, got %d", actGzipWriter.OutputBytes(), actBuffer.Len())
+		}
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer.Buffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if string(result) != "hello" {
+			t.Fatalf("expected %q, got %q", "hello", result)
+		}
+	})
+
+	t.Run("close propagates flush error", func(t *testing.T) {
+		t.Parallel()
+
+		// The whole stream fits in the buffer, so the only write to the
+		// destination happens when Close flushes it.
+		destination := failingWriter{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&destination)
+		if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Close(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if err := actGzipWriter.Err(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		// A second Close must not report success.
+		if err := actGzipWriter.Close(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if destination.writes != 1 {
+			t.Fatalf("expected a single write, got %d writes", destination.writes)
+		}
+	})
+}
+
+func BenchmarkWriteCompressedBuffering(b *testing.B) {
+	blob := compressStdlib(b, []byte("hello, world!"))
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	constructors := []struct {
+		note string
+		new  func(io.Writer) *gzipstreamwriter.GzipStreamWriter
+	}{
+		{
+			note: "unbuffered",
+			new: func(w io.Writer) *gzipstreamwriter.GzipStreamWriter {
+				return gzipstreamwriter.NewGzipStreamWriter(w)
+			},
+		},
+		{
+			note: "buffered",
+			new: func(w io.Writer) *gzipstreamwriter.GzipStreamWriter {
+				return gzipstreamwriter.NewGzipStreamWriterBuffered(w)
+			},
+		},
+	}
+
+	for _, c := range constructors {
+		b.Run(c.note, func(b *testing.B) {
+			z := c.new(devNull)
+			for b.Loop() {
+				z.Reset(devNull)
+				for range 100 {
+					if _, err := z.WriteCompressed(blob); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStreamWriterChecksum(t *testing.T) {
+	t.Parallel()
+
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	input := bytes.Repeat([]byte("hello, world! "), 100)
+
+	t.Run("trailer uses table", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewStreamWriterChecksum(&actBuffer, gzipstreamwriter.DefaultCompression, castagnoli)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		out := actBuffer.Bytes()
+		if checksum := binary.LittleEndian.Uint32(out[len(out)-8:]); checksum != crc32.Checksum(input, castagnoli) {
+			t.Fatalf("expected CRC32-C %08x, got %08x", crc32.Checksum(input, castagnoli), checksum)
+		}
+		// Standard readers must reject the non-IEEE checksum.
+		if _, err := gzipstreamwriter.DecompressAll(bytes.NewReader(out)); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+
+	t.Run("nil table is same as IEEE", func(t *testing.T) {
+		t.Parallel()
+
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewStreamWriterChecksum(&actBuffer, gzipstreamwriter.DefaultCompression, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestNewStreamWriterChecksum() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewStreamWriterChecksum(io.Discard, -3, castagnoli); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrInvalidCompressionLevel, err)
+		}
+	})
+}
+
+func TestWriteCompressedTruncatedHeader(t *testing.T) {
+	t.Parallel()
+
+	// A blob whose Extra field claims to be far longer than the blob itself.
+	blob := compressStdlib(t, []byte("hello, world!"))
+	blob[3] |= 0x04
+	if _, err := gzipstreamwriter.NewGzipStreamWriter(io.Discard).WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrTruncatedHeader) {
+		t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrTruncatedHeader, err)
+	}
+
+	// Bad magic bytes are not a truncation.
+	blob = compressStdlib(t, []byte("hello, world!"))
+	blob[0] = 'x'
+	_, err := gzipstreamwriter.NewGzipStreamWriter(io.Discard).WriteCompressed(blob)
+	if !errors.Is(err, gzipstreamwriter.ErrBlob) || errors.Is(err, gzipstreamwriter.ErrTruncatedHeader) {
+		t.Fatalf("expected error %v only, got %v", gzipstreamwriter.ErrBlob, err)
+	}
+}
+
+func TestSetFinalISIZE(t *testing.T) {
+	t.Parallel()
+
+	input := []byte("hello, world!")
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	actGzipWriter.SetFinalISIZE(42)
+	if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+		t.Fatal(err)
+	}
+	out := actBuffer.Bytes()
+	if isize := binary.LittleEndian.Uint32(out[len(out)-4:]); isize != 42 {
+		t.Fatalf("expected ISIZE %d, got %d", 42, isize)
+	}
+	if _, err := gzipstreamwriter.DecompressAll(bytes.NewReader(out)); !errors.Is(err, gzip.ErrChecksum) {
+		t.Fatalf("expected error %v, got %v", gzip.ErrChecksum, err)
+	}
+
+	// Reset clears the override.
+	actBuffer.Reset()
+	actGzipWriter.Reset(&actBuffer)
+	if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+		t.Fatal(err)
+	}
+	out = actBuffer.Bytes()
+	if isize := binary.LittleEndian.Uint32(out[len(out)-4:]); isize != uint32(len(input)) {
+		t.Fatalf("expected ISIZE %d, got %d", len(input), isize)
+	}
+}
+
+func TestWriteCompressedHeaderOnce(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, []byte("hello, world!"))
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	actGzipWriter.Name = "snapshot.json"
+	for range 3 {
+		if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := bytes.Count(actBuffer.Bytes(), []byte("snapshot.json")); count != 1 {
+		t.Fatalf("expected header to be written once, found it %d times", count)
+	}
+}
+
+func BenchmarkWriteCompressedConsecutive(b *testing.B) {
+	blob := compressStdlib(b, []byte("hello, world!"))
+	z := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+	for b.Loop() {
+		z.Reset(io.Discard)
+		for range 10000 {
+			if _, err := z.WriteCompressed(blob); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTrimBlob(t *testing.T) {
+	t.Parallel()
+
+	input := bytes.Repeat([]byte("hello, world! "), 100)
+	var expDeflate bytes.Buffer
+	compressor, _ := flate.NewWriter(&expDeflate, flate.DefaultCompression)
+	if _, err := writeToBuffer(t, compressor, input); err != nil {
+		t.Fatal(err)
+	}
+
+	var blobBuffer bytes.Buffer
+	w := gzip.NewWriter(&blobBuffer)
+	w.Name = "events.json"
+	w.Comment = "a comment"
+	if _, err := writeToBuffer(t, w, input); err != nil {
+		t.Fatal(err)
+	}
+
+	deflate, checksum, isize, err := gzipstreamwriter.TrimBlob(blobBuffer.Bytes())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if diff := cmp.Diff(expDeflate.Bytes(), deflate); diff != "" {
+		t.Fatalf("TestTrimBlob() mismatch (-want +got):\n%s", diff)
+	}
+	if checksum != crc32.ChecksumIEEE(input) {
+		t.Fatalf("expected CRC32 %08x, got %08x", crc32.ChecksumIEEE(input), checksum)
+	}
+	if isize != uint32(len(input)) {
+		t.Fatalf("expected ISIZE %d, got %d", len(input), isize)
+	}
+
+	for _, bad := range [][]byte{nil, blobBuffer.Bytes()[:17], []byte("definitely not a gzip blob")} {
+		if _, _, _, err := gzipstreamwriter.TrimBlob(bad); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	}
+}
+
+func TestAddExtraSubfield(t *testing.T) {
+	t.Parallel()
+
+	t.Run("subfields are written in order", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		if err := actGzipWriter.AddExtraSubfield('B', 'C', []byte{0x34, 0x12}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.AddExtraSubfield('A', 'p', nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+
+		gzReader, err := gzip.NewReader(&actBuffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expExtra := []byte{'B', 'C', 2, 0, 0x34, 0x12, 'A', 'p', 0, 0}
+		if diff := cmp.Diff(expExtra, gzReader.Extra); diff != "" {
+			t.Fatalf("TestAddExtraSubfield() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("oversized extra field", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if err := actGzipWriter.AddExtraSubfield('A', 'a', make([]byte, 0xffff-8)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.AddExtraSubfield('B', 'b', []byte{1}); !errors.Is(err, gzipstreamwriter.ErrHdrExtaDataTooLarge) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrHdrExtaDataTooLarge, err)
+		}
+		if len(actGzipWriter.Extra) != 0xffff-4 {
+			t.Fatalf("expected Extra field to be unchanged, got %d bytes", len(actGzipWriter.Extra))
+		}
+	})
+}
+
+func TestStateInspection(t *testing.T) {
+	t.Parallel()
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	if actGzipWriter.HeaderWritten() || actGzipWriter.Closed() {
+		t.Fatalf("expected fresh writer to have no header written and not be closed")
+	}
+
+	if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if !actGzipWriter.HeaderWritten() {
+		t.Fatalf("expected header to be written after Write")
+	}
+	if actGzipWriter.Closed() {
+		t.Fatalf("expected writer to not be closed after Write")
+	}
+	if !actGzipWriter.InDeflateStream() {
+		t.Fatalf("expected writer to be in a DEFLATE stream after Write")
+	}
+
+	if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, []byte(", world!"))); err != nil {
+		t.Fatal(err)
+	}
+	if actGzipWriter.InDeflateStream() {
+		t.Fatalf("expected writer to not be in a DEFLATE stream after WriteCompressed")
+	}
+	if _, err := actGzipWriter.Write([]byte("\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if actGzipWriter.InDeflateStream() {
+		t.Fatalf("expected writer to not be in a DEFLATE stream after Flush")
+	}
+
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !actGzipWriter.Closed() {
+		t.Fatalf("expected writer to be closed after Close")
+	}
+
+	actGzipWriter.Reset(&actBuffer)
+	if actGzipWriter.HeaderWritten() || actGzipWriter.Closed() {
+		t.Fatalf("expected reset writer to have no header written and not be closed")
+	}
+}
+
+func TestHeaderSetters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("setters apply before the header is written", func(t *testing.T) {
+		t.Parallel()
+
+		modTime := time.Unix(1700000000, 0)
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		if err := actGzipWriter.SetName("data.txt"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.SetComment("caf\u00e9"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.SetModTime(modTime); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.SetExtra([]byte{'A', 'p', 0, 0}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.SetOS(3); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+
+		gzReader, err := gzip.NewReader(&actBuffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expHeader := gzip.Header{
+			Name:    "data.txt",
+			Comment: "caf\u00e9",
+			ModTime: modTime,
+			Extra:   []byte{'A', 'p', 0, 0},
+			OS:      3,
+		}
+		if diff := cmp.Diff(expHeader, gzReader.Header); diff != "" {
+			t.Fatalf("TestHeaderSetters() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("setters fail after the header is written", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+
+		setters := map[string]func() error{
+			"SetName":    func() error { return actGzipWriter.SetName("late.txt") },
+			"SetComment": func() error { return actGzipWriter.SetComment("late") },
+			"SetModTime": func() error { return actGzipWriter.SetModTime(time.Now()) },
+			"SetExtra":   func() error { return actGzipWriter.SetExtra([]byte{'A', 'p', 0, 0}) },
+			"SetOS":      func() error { return actGzipWriter.SetOS(3) },
+			"AddExtraSubfield": func() error {
+				return actGzipWriter.AddExtraSubfield('A', 'p', nil)
+			},
+		}
+		for name, setter := range setters {
+			if err := setter(); !errors.Is(err, gzipstreamwriter.ErrHeaderAlreadyWritten) {
+				t.Fatalf("%s: expected error %v, got %v", name, gzipstreamwriter.ErrHeaderAlreadyWritten, err)
+			}
+		}
+		if actGzipWriter.Name != "" || actGzipWriter.Comment != "" || len(actGzipWriter.Extra) != 0 {
+			t.Fatalf("expected header to be unchanged, got %+v", actGzipWriter.Header)
+		}
+	})
+
+	t.Run("non-Latin-1 strings are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if err := actGzipWriter.SetName("\u2603"); !errors.Is(err, gzipstreamwriter.ErrHdrNonLatin1) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrHdrNonLatin1, err)
+		}
+		if err := actGzipWriter.SetComment("a\x00b"); !errors.Is(err, gzipstreamwriter.ErrHdrNonLatin1) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrHdrNonLatin1, err)
+		}
+	})
+}
+
+func TestResetWithHeader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("header is applied to the next stream", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		actGzipWriter.Name = "first.txt"
+		if _, err := writeToBuffer(t, actGzipWriter, []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+
+		expHeader := gzip.Header{
+			Name:    "second.txt",
+			Comment: "second stream",
+			ModTime: time.Unix(1700000000, 0),
+			OS:      255,
+		}
+		actBuffer := bytes.Buffer{}
+		if err := actGzipWriter.ResetWithHeader(&actBuffer, expHeader); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := writeToBuffer(t, actGzipWriter, []byte("second")); err != nil {
+			t.Fatal(err)
+		}
+
+		gzReader, err := gzip.NewReader(&actBuffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expHeader, gzReader.Header); diff != "" {
+			t.Fatalf("TestResetWithHeader() mismatch (-want +got):\n%s", diff)
+		}
+		result, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result) != "second" {
+			t.Fatalf("expected %q, got %q", "second", result)
+		}
+	})
+
+	t.Run("invalid header leaves writer unchanged", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		if _, err := actGzipWriter.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+
+		err := actGzipWriter.ResetWithHeader(io.Discard, gzip.Header{Name: "\u2603"})
+		if !errors.Is(err, gzipstreamwriter.ErrHdrNonLatin1) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrHdrNonLatin1, err)
+		}
+		if !actGzipWriter.HeaderWritten() {
+			t.Fatalf("expected writer to not be reset")
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result) != "hello" {
+			t.Fatalf("expected %q, got %q", "hello", result)
+		}
+	})
+}
+
+func TestEstimatedSize(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, []byte("hello, world!"))
+
+	testcases := []struct {
+		note  string
+		level int
+		setup func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter)
+	}{
+		{
+			note:  "empty stream",
+			level: gzipstreamwriter.DefaultCompression,
+			setup: func(*testing.T, *gzipstreamwriter.GzipStreamWriter) {},
+		},
+		{
+			note:  "header fields",
+			level: gzipstreamwriter.BestSpeed,
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				z.Name = "caf\u00e9.txt"
+				z.Comment = "comment"
+				z.Extra = []byte{'A', 'p', 0, 0}
+			},
+		},
+		{
+			note:  "compressed blobs only",
+			level: gzipstreamwriter.DefaultCompression,
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				for range 3 {
+					if _, err := z.WriteCompressed(blob); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			note:  "flushed writes",
+			level: gzipstreamwriter.NoCompression,
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				if _, err := z.Write(bytes.Repeat([]byte("ABCD"), 1000)); err != nil {
+					t.Fatal(err)
+				}
+				if err := z.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			note:  "flushed writes, huffman only",
+			level: gzipstreamwriter.HuffmanOnly,
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				if _, err := z.Write(bytes.Repeat([]byte("ABCD"), 1000)); err != nil {
+					t.Fatal(err)
+				}
+				if err := z.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, tc.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.setup(t, actGzipWriter)
+
+			estimate := actGzipWriter.EstimatedSize()
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if estimate != int64(actBuffer.Len()) {
+				t.Fatalf("expected estimate of %d bytes, got %d bytes", actBuffer.Len(), estimate)
+			}
+			if closedSize := actGzipWriter.EstimatedSize(); closedSize != int64(actBuffer.Len()) {
+				t.Fatalf("expected size of %d bytes after Close, got %d bytes", actBuffer.Len(), closedSize)
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	t.Parallel()
+
+	t.Run("aborted stream writes nothing further", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&actBuffer)
+		if _, err := actGzipWriter.Write([]byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Abort(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected buffered output to be discarded, got %d bytes", actBuffer.Len())
+		}
+
+		if _, err := actGzipWriter.Write([]byte("more")); !errors.Is(err, gzipstreamwriter.ErrAborted) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrAborted, err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, []byte("more"))); !errors.Is(err, gzipstreamwriter.ErrAborted) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrAborted, err)
+		}
+		if err := actGzipWriter.Close(); !errors.Is(err, gzipstreamwriter.ErrAborted) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrAborted, err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected no output after Abort, got %d bytes", actBuffer.Len())
+		}
+		if !actGzipWriter.Closed() {
+			t.Fatalf("expected aborted writer to report closed")
+		}
+	})
+
+	t.Run("writer is reusable after Reset", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.Write(bytes.Repeat([]byte("abandoned"), 1000)); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Abort(); err != nil {
+			t.Fatal(err)
+		}
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter.Reset(&actBuffer)
+		if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if string(result) != "hello, world!" {
+			t.Fatalf("expected %q, got %q", "hello, world!", result)
+		}
+	})
+
+	t.Run("abort after close does nothing", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Abort(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+}
+
+func TestWithXFL(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note   string
+		level  int
+		opts   []gzipstreamwriter.Option
+		expXFL byte
+	}{
+		{
+			note:   "default level, no override",
+			level:  gzipstreamwriter.DefaultCompression,
+			expXFL: 0,
+		},
+		{
+			note:   "best compression, no override",
+			level:  gzipstreamwriter.BestCompression,
+			expXFL: 2,
+		},
+		{
+			note:   "default level, override",
+			level:  gzipstreamwriter.DefaultCompression,
+			opts:   []gzipstreamwriter.Option{gzipstreamwriter.WithXFL(2)},
+			expXFL: 2,
+		},
+		{
+			note:   "best speed, override",
+			level:  gzipstreamwriter.BestSpeed,
+			opts:   []gzipstreamwriter.Option{gzipstreamwriter.WithXFL(0)},
+			expXFL: 0,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, tc.level, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+				t.Fatal(err)
+			}
+			if actBuffer.Bytes()[8] != tc.expXFL {
+				t.Fatalf("expected XFL %d, got %d", tc.expXFL, actBuffer.Bytes()[8])
+			}
+
+			// The override is kept across Reset.
+			actBuffer.Reset()
+			actGzipWriter.Reset(&actBuffer)
+			if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+				t.Fatal(err)
+			}
+			if actBuffer.Bytes()[8] != tc.expXFL {
+				t.Fatalf("expected XFL %d after Reset, got %d", tc.expXFL, actBuffer.Bytes()[8])
+			}
+		})
+	}
+}
+
+func TestCombinedTrailer(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("hello, "),
+		bytes.Repeat([]byte("world"), 1000),
+		[]byte("!"),
+	}
+	blobs := make([][]byte, 0, len(inputs))
+	for _, input := range inputs {
+		blobs = append(blobs, compressStdlib(t, input))
+	}
+
+	t.Run("matches written trailer", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		for _, blob := range blobs {
+			if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		trailer := actBuffer.Bytes()[actBuffer.Len()-8:]
+
+		checksum, size, err := gzipstreamwriter.CombinedTrailer(blobs)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if exp := binary.LittleEndian.Uint32(trailer[:4]); checksum != exp {
+			t.Fatalf("expected CRC32 %08x, got %08x", exp, checksum)
+		}
+		if exp := binary.LittleEndian.Uint32(trailer[4:]); size != exp {
+			t.Fatalf("expected ISIZE %d, got %d", exp, size)
+		}
+
+		all := slices.Concat(inputs...)
+		if exp := crc32.ChecksumIEEE(all); checksum != exp {
+			t.Fatalf("expected CRC32 %08x, got %08x", exp, checksum)
+		}
+		if size != uint32(len(all)) {
+			t.Fatalf("expected ISIZE %d, got %d", len(all), size)
+		}
+	})
+
+	t.Run("no blobs", func(t *testing.T) {
+		t.Parallel()
+
+		checksum, size, err := gzipstreamwriter.CombinedTrailer(nil)
+		if err != nil || checksum != 0 || size != 0 {
+			t.Fatalf("expected (0, 0, nil), got (%d, %d, %v)", checksum, size, err)
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := gzipstreamwriter.CombinedTrailer([][]byte{blobs[0], []byte("not a gzip blob")})
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestWriteCompressedEmptyMember(t *testing.T) {
+	t.Parallel()
+
+	emptyBlob := compressStdlib(t, nil)
+	helloBlob := compressStdlib(t, []byte("hello, "))
+	worldBlob := compressStdlib(t, []byte("world!"))
+
+	testcases := []struct {
+		note  string
+		blobs [][]byte
+		exp   string
+	}{
+		{
+			note:  "empty member alone",
+			blobs: [][]byte{emptyBlob},
+			exp:   "",
+		},
+		{
+			note:  "empty members between others",
+			blobs: [][]byte{emptyBlob, helloBlob, emptyBlob, emptyBlob, worldBlob, emptyBlob},
+			exp:   "hello, world!",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			for _, blob := range tc.blobs {
+				if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The output is a single member, so the reader checks the
+			// combined CRC32 and size against all of the data.
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gzReader.Multistream(false)
+			result, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(result) != tc.exp {
+				t.Fatalf("expected %q, got %q", tc.exp, result)
+			}
+			if actBuffer.Len() != 0 {
+				t.Fatalf("expected a single member, got %d trailing bytes", actBuffer.Len())
+			}
+		})
+	}
+
+	t.Run("empty member keeps the digest", func(t *testing.T) {
+		t.Parallel()
+
+		checksum, size, err := gzipstreamwriter.CombinedTrailer([][]byte{helloBlob, emptyBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expChecksum, expSize, err := gzipstreamwriter.CombinedTrailer([][]byte{helloBlob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != expChecksum || size != expSize {
+			t.Fatalf("expected (%08x, %d), got (%08x, %d)", expChecksum, expSize, checksum, size)
+		}
+	})
+}
+
+func TestWriteCompressedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		[]byte("A"),
+		bytes.Repeat([]byte("ABCD"), 1000),
+		randomTestBytes(70000),
+		nil,
+		bytes.Repeat([]byte("The quick brown fox. "), 500),
+	}
+	blobs := make([][]byte, 0, len(inputs))
+	for _, input := range inputs {
+		blobs = append(blobs, compressStdlib(t, input))
+	}
+	expResult := slices.Concat(inputs...)
+
+	t.Run("WriteCompressed", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		for _, blob := range blobs {
+			if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(expResult, result) {
+			t.Fatalf("expected %d bytes of output, got %d bytes", len(expResult), len(result))
+		}
+	})
+
+	t.Run("WriteCompressedReader", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		for _, blob := range blobs {
+			if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(expResult, result) {
+			t.Fatalf("expected %d bytes of output, got %d bytes", len(expResult), len(result))
+		}
+	})
+
+	t.Run("trailing data after the deflate stream", func(t *testing.T) {
+		t.Parallel()
+
+		content, checksum, length, err := gzipstreamwriter.TrimBlob(blobs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob := slices.Concat(blobs[0][:len(blobs[0])-len(content)-8], content, []byte{0xde, 0xad})
+		blob = binary.LittleEndian.AppendUint32(blob, checksum)
+		blob = binary.LittleEndian.AppendUint32(blob, length)
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		actGzipWriter = gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestWithMaxMembers(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, []byte("hello, world!"))
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithMaxMembers(2))
+	if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	written := actBuffer.Len()
+	if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrTooManyMembers) {
+		t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrTooManyMembers, err)
+	}
+	if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); !errors.Is(err, gzipstreamwriter.ErrTooManyMembers) {
+		t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrTooManyMembers, err)
+	}
+	if actBuffer.Len() != written {
+		t.Fatalf("expected no output for rejected blobs, got %d bytes", actBuffer.Len()-written)
+	}
+
+	// The limit does not break the stream, which can still be finished.
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "hello, world!hello, world!"; string(result) != exp {
+		t.Fatalf("expected %q, got %q", exp, result)
+	}
+
+	// The count starts over on Reset.
+	actGzipWriter.Reset(io.Discard)
+	for range 2 {
+		if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatalf("expected no error after Reset, got %v", err)
+		}
+	}
+	if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrTooManyMembers) {
+		t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrTooManyMembers, err)
+	}
+}
+
+func TestResumeDigest(t *testing.T) {
+	t.Parallel()
+
+	blobs := [][]byte{
+		compressStdlib(t, []byte("hello, ")),
+		compressStdlib(t, bytes.Repeat([]byte("world"), 100)),
+		compressStdlib(t, []byte("!")),
+	}
+
+	// The expected output is all of the blobs, written by a single writer.
+	expOutput, err := gzipstreamwriter.AssembleGolden(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("resumed stream matches uninterrupted stream", func(t *testing.T) {
+		t.Parallel()
+
+		firstBuffer := bytes.Buffer{}
+		firstGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&firstBuffer)
+		for _, blob := range blobs[:2] {
+			if _, err := firstGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checksum, size := firstGzipWriter.Digest()
+		expChecksum, expSize, err := gzipstreamwriter.CombinedTrailer(blobs[:2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != expChecksum || size != expSize {
+			t.Fatalf("expected digest (%08x, %d), got (%08x, %d)", expChecksum, expSize, checksum, size)
+		}
+
+		// Continue the stream in a fresh writer, as after a restart.
+		secondBuffer := bytes.Buffer{}
+		secondGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&secondBuffer)
+		if err := secondGzipWriter.ResumeDigest(checksum, size); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := secondGzipWriter.WriteCompressed(blobs[2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := secondGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		actOutput := slices.Concat(firstBuffer.Bytes(), secondBuffer.Bytes())
+		if diff := cmp.Diff(expOutput, actOutput); diff != "" {
+			t.Fatalf("TestResumeDigest() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("resume after header is written", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.WriteCompressed(blobs[0]); err != nil {
+			t.Fatal(err)
+		}
+		expChecksum, expSize := actGzipWriter.Digest()
+		if err := actGzipWriter.ResumeDigest(1, 2); !errors.Is(err, gzipstreamwriter.ErrHeaderAlreadyWritten) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrHeaderAlreadyWritten, err)
+		}
+		if checksum, size := actGzipWriter.Digest(); checksum != expChecksum || size != expSize {
+			t.Fatalf("expected digest (%08x, %d), got (%08x, %d)", expChecksum, expSize, checksum, size)
+		}
+	})
+}
+
+func TestWriteDeflate(t *testing.T) {
+	t.Parallel()
+
+	blobs := [][]byte{
+		compressStdlib(t, []byte("hello, ")),
+		compressStdlib(t, nil),
+		compressStdlib(t, bytes.Repeat([]byte("world! "), 1000)),
+	}
+	expOutput, err := gzipstreamwriter.AssembleGolden(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("same as WriteCompressed", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		for _, blob := range blobs {
+			deflate, checksum, isize, err := gzipstreamwriter.TrimBlob(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := actGzipWriter.WriteDeflate(deflate, checksum, isize); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(expOutput, actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWriteDeflate() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("trailer fields must match an empty stream", func(t *testing.T) {
+		t.Parallel()
+
+		deflate, _, _, err := gzipstreamwriter.TrimBlob(blobs[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.WriteDeflate(deflate, 0, 5); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if _, err := actGzipWriter.WriteDeflate(deflate, 0x12345678, 0); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if _, err := actGzipWriter.WriteDeflate(deflate, 0, 0); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("invalid deflate stream", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.WriteDeflate([]byte{0xff, 0xff}, 0, 0); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestString(t *testing.T) {
+	t.Parallel()
+
+	t.Run("nil and zero value", func(t *testing.T) {
+		t.Parallel()
+
+		var nilGzipWriter *gzipstreamwriter.GzipStreamWriter
+		if s := nilGzipWriter.String(); s != "GzipStreamWriter(nil)" {
+			t.Fatalf("expected %q, got %q", "GzipStreamWriter(nil)", s)
+		}
+		exp := "GzipStreamWriter{level: 0, wroteHeader: false, closed: false, activeDeflateStream: false, size: 0, digest: 0x00000000}"
+		if s := new(gzipstreamwriter.GzipStreamWriter).String(); s != exp {
+			t.Fatalf("expected %q, got %q", exp, s)
+		}
+	})
+
+	t.Run("active stream", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		if _, err := actGzipWriter.Write([]byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+		exp := "GzipStreamWriter{level: -1, wroteHeader: true, closed: false, activeDeflateStream: true, size: 13, digest: 0x58988d13}"
+		if s := fmt.Sprintf("%s", actGzipWriter); s != exp {
+			t.Fatalf("expected %q, got %q", exp, s)
+		}
+	})
+}
+
+func TestReadFrom(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(200_000)
+
+	for _, chunkSize := range []int{0, 1000, 32 * 1024, 1 << 20} {
+		t.Run(fmt.Sprintf("chunk size %d", chunkSize), func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithReadChunkSize(chunkSize))
+			// Reading one byte at a time exercises short reads.
+			n, err := io.Copy(actGzipWriter, iotest.OneByteReader(bytes.NewReader(input[:1000])))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if n != 1000 {
+				t.Fatalf("expected 1000 bytes read, got %d", n)
+			}
+			n, err = actGzipWriter.ReadFrom(bytes.NewReader(input[1000:]))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if n != int64(len(input)-1000) {
+				t.Fatalf("expected %d bytes read, got %d", len(input)-1000, n)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(input, actual); diff != "" {
+				t.Fatalf("TestReadFrom() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		t.Parallel()
+
+		errTestRead := errors.New("test: read failed")
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		source := io.MultiReader(bytes.NewReader(input[:100]), iotest.ErrReader(errTestRead))
+		n, err := actGzipWriter.ReadFrom(source)
+		if !errors.Is(err, errTestRead) {
+			t.Fatalf("expected error %v, got %v", errTestRead, err)
+		}
+		if n != 100 {
+			t.Fatalf("expected 100 bytes read, got %d", n)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&failingWriter{})
+		if _, err := actGzipWriter.ReadFrom(bytes.NewReader(input)); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+	})
+}
+
+func BenchmarkReadFromChunkSize(b *testing.B) {
+	input := randomTestBytes(8 << 20)
+	for _, chunkSize := range []int{4 * 1024, 32 * 1024, 256 * 1024, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", chunkSize/1024), func(b *testing.B) {
+			z := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.WithReadChunkSize(chunkSize))
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				z.Reset(io.Discard)
+				if _, err := z.ReadFrom(bytes.NewReader(input)); err != nil {
+					b.Fatal(err)
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCallSequences(t *testing.T) {
+	t.Parallel()
+
+	// Repetitive input, so that the compressor emits back-references, which
+	// must not reach back past a spliced blob.
+	text := bytes.Repeat([]byte("hello, world! the quick brown fox. "), 100)
+	blobInput := randomTestBytes(5000)
+	blob := compressStdlib(t, blobInput)
+
+	// Each step is one call: Write, WriteCompressed, WriteCompressedReader,
+	// Flush, or NextMember. Close is called at the end of every sequence.
+	testCases := []struct {
+		name  string
+		steps string
+	}{
+		{name: "Write Flush WriteCompressed", steps: "WFC"},
+		{name: "WriteCompressed Write", steps: "CW"},
+		{name: "Write WriteCompressed Write", steps: "WCW"},
+		{name: "Write Flush WriteCompressed Write", steps: "WFCW"},
+		{name: "Write WriteCompressedReader Write", steps: "WRW"},
+		{name: "Flush only", steps: "F"},
+		{name: "Flush twice", steps: "WFF"},
+		{name: "blobs back to back", steps: "CCWCC"},
+		{name: "Write NextMember WriteCompressed Write", steps: "WNCW"},
+		{name: "everything", steps: "WWFCWRFNWCFWW"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			expected := []byte{}
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			for _, step := range tc.steps {
+				var err error
+				switch step {
+				case 'W':
+					_, err = actGzipWriter.Write(text)
+					expected = append(expected, text...)
+				case 'C':
+					_, err = actGzipWriter.WriteCompressed(blob)
+					expected = append(expected, blobInput...)
+				case 'R':
+					err = actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob))
+					expected = append(expected, blobInput...)
+				case 'F':
+					err = actGzipWriter.Flush()
+				case 'N':
+					err = actGzipWriter.NextMember()
+				}
+				if err != nil {
+					t.Fatalf("step %c: expected no error, got %v", step, err)
+				}
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// Extra calls after Close do nothing.
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Flush(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			actual, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(actBuffer.Bytes())))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestCallSequences() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("preset dictionary", func(t *testing.T) {
+		t.Parallel()
+
+		dict := text[:1000]
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterDict(&actBuffer, gzipstreamwriter.DefaultCompression, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			actBuffer.Reset()
+			actGzipWriter.Reset(&actBuffer)
+			if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.Write(text); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			actual := decompressDeflateDict(t, actBuffer.Bytes(), dict)
+			if diff := cmp.Diff(slices.Concat(blobInput, text), actual); diff != "" {
+				t.Fatalf("TestCallSequences() mismatch (-want +got):\n%s", diff)
+			}
+		}
+
+		// After Reset, the dictionary is back in use.
+		actBuffer.Reset()
+		actGzipWriter.Reset(&actBuffer)
+		if _, err := actGzipWriter.Write(dict); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(dict, decompressDeflateDict(t, actBuffer.Bytes(), dict)); diff != "" {
+			t.Fatalf("TestCallSequences() mismatch (-want +got):\n%s", diff)
+		}
+		if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err == nil {
+			t.Fatalf("expected output that needs the dictionary, got a plain gzip stream")
+		}
+	})
+}
+
+func TestWithAutoLevel(t *testing.T) {
+	t.Parallel()
+
+	small := []byte("hello, world!")
+	large := bytes.Repeat([]byte("hello, world! "), 1000)
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, gzipstreamwriter.BestCompression, gzipstreamwriter.WithAutoLevel(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		actBuffer.Reset()
+		actGzipWriter.Reset(&actBuffer)
+		for _, input := range [][]byte{small, large, small} {
+			if _, err := actGzipWriter.Write(input); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.NextMember(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		members, err := gzipstreamwriter.SplitMembers(actBuffer.Bytes())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// The last, empty member keeps the previous member's level.
+		expStored := []bool{true, false, true, true}
+		if len(members) != len(expStored) {
+			t.Fatalf("expected %d members, got %d", len(expStored), len(members))
+		}
+		for i, member := range members {
+			// With no optional header fields, the DEFLATE payload starts at
+			// offset 10. Its first block's BTYPE is 00 for a stored block.
+			// An empty member has no data blocks to check.
+			stored := (member[10]>>1)&0x3 == 0
+			if i < 3 && stored != expStored[i] {
+				t.Fatalf("member %d: expected stored %t, got %t", i, expStored[i], stored)
+			}
+			expXFL := byte(2)
+			if expStored[i] {
+				expXFL = 0
+			}
+			if member[8] != expXFL {
+				t.Fatalf("member %d: expected XFL %d, got %d", i, expXFL, member[8])
+			}
+		}
+		if len(members[1]) > len(large)/10 {
+			t.Fatalf("expected large member to be compressed, got %d bytes", len(members[1]))
+		}
+
+		actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(slices.Concat(small, large, small), actual); diff != "" {
+			t.Fatalf("TestWithAutoLevel() mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestWithAutoLevelReset(t *testing.T) {
+	t.Parallel()
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, gzipstreamwriter.BestCompression, gzipstreamwriter.WithAutoLevel(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The small write leaves the writer storing members.
+	if _, err := actGzipWriter.Write([]byte("hello, world!")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if actBuffer.Bytes()[8] != 0 {
+		t.Fatalf("expected XFL 0, got %d", actBuffer.Bytes()[8])
+	}
+
+	// After a reset, a member that is not started by Write must be back at
+	// the writer's own level.
+	actBuffer.Reset()
+	actGzipWriter.Reset(&actBuffer)
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if actBuffer.Bytes()[8] != 2 {
+		t.Fatalf("expected XFL 2, got %d", actBuffer.Bytes()[8])
+	}
+	if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestWriteStored(t *testing.T) {
+	t.Parallel()
+
+	text := bytes.Repeat([]byte("hello, world! "), 100)
+	stored := randomTestBytes(200_000)
+
+	testCases := []struct {
+		name  string
+		input [][]byte // Written with Write and WriteStored, alternately.
+	}{
+		{name: "stored only", input: [][]byte{nil, stored}},
+		{name: "between writes", input: [][]byte{text, stored, text}},
+		{name: "exactly one block", input: [][]byte{text, stored[:0xffff], text}},
+		{name: "empty", input: [][]byte{text, nil, text}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			storedBytes := 0
+			for i, input := range tc.input {
+				var n int
+				var err error
+				if i%2 == 0 {
+					n, err = actGzipWriter.Write(input)
+				} else {
+					n, err = actGzipWriter.WriteStored(input)
+					storedBytes += len(input)
+				}
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if n != len(input) {
+					t.Fatalf("expected %d bytes written, got %d", len(input), n)
+				}
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// Stored data takes up its own size, plus 5 bytes per block.
+			if actBuffer.Len() < storedBytes+5*(storedBytes/0xffff) {
+				t.Fatalf("expected at least %d bytes of output, got %d", storedBytes, actBuffer.Len())
+			}
+			actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(slices.Concat(tc.input...), actual); diff != "" {
+				t.Fatalf("TestWriteStored() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("write error", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&failingWriter{okWrites: 1})
+		if _, err := actGzipWriter.WriteStored(stored); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+	})
+}
+
+func BenchmarkWriteStored(b *testing.B) {
+	input := randomTestBytes(4 << 20)
+
+	b.Run("WriteStored", func(b *testing.B) {
+		z := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		b.SetBytes(int64(len(input)))
+		for b.Loop() {
+			z.Reset(io.Discard)
+			if _, err := z.WriteStored(input); err != nil {
+				b.Fatal(err)
+			}
+			if err := z.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Write NoCompression", func(b *testing.B) {
+		z, err := gzipstreamwriter.NewGzipStreamWriterLevel(io.Discard, gzipstreamwriter.NoCompression)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(input)))
+		for b.Loop() {
+			z.Reset(io.Discard)
+			if _, err := z.Write(input); err != nil {
+				b.Fatal(err)
+			}
+			if err := z.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWriteCompressedN(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("hello, world!"), nil, randomTestBytes(100_000)}
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	for _, input := range inputs {
+		blob := compressStdlib(t, input)
+		before := actBuffer.Len()
+		n, isize, err := actGzipWriter.WriteCompressedN(blob)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if isize != uint32(len(input)) {
+			t.Fatalf("expected ISIZE %d, got %d", len(input), isize)
+		}
+		if n != actBuffer.Len()-before {
+			t.Fatalf("expected %d bytes written, got %d", actBuffer.Len()-before, n)
+		}
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if diff := cmp.Diff(slices.Concat(inputs...), actual); diff != "" {
+		t.Fatalf("TestWriteCompressedN() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		n, isize, err := actGzipWriter.WriteCompressedN([]byte("definitely not a gzip blob"))
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if n != 0 || isize != 0 {
+			t.Fatalf("expected 0 bytes written and ISIZE 0, got %d and %d", n, isize)
+		}
+	})
+}
+
+func TestWithMaxOutputBytes(t *testing.T) {
+	t.Parallel()
+
+	blob := compressStdlib(t, randomTestBytes(1000))
+	// Measure how much output each blob adds.
+	measureBuffer := bytes.Buffer{}
+	measureGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&measureBuffer)
+	if _, err := measureGzipWriter.WriteCompressed(blob); err != nil {
+		t.Fatal(err)
+	}
+	first := measureBuffer.Len()
+
+	t.Run("blobs", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithMaxOutputBytes(int64(first+100)))
+		if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrOutputLimitExceeded, err)
+		}
+		if actBuffer.Len() != first {
+			t.Fatalf("expected %d bytes written, got %d", first, actBuffer.Len())
+		}
+		if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrOutputLimitExceeded, err)
+		}
+		if _, err := actGzipWriter.WriteStored(make([]byte, 101)); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrOutputLimitExceeded, err)
+		}
+		// The writer is still usable, and the rejected calls left no trace.
+		if _, err := actGzipWriter.WriteStored(make([]byte, 95)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actBuffer.Len() != first+100 {
+			t.Fatalf("expected %d bytes written, got %d", first+100, actBuffer.Len())
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("blob too large for the first write", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithMaxOutputBytes(int64(first-1)))
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrOutputLimitExceeded, err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+		}
+	})
+
+	t.Run("writes", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithMaxOutputBytes(1000))
+		input := randomTestBytes(1800)
+		// Compressed sizes are only known once flushed.
+		for i := range 2 {
+			if _, err := actGzipWriter.Write(input[i*600 : (i+1)*600]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Flush(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if _, err := actGzipWriter.Write(input[1200:]); !errors.Is(err, gzipstreamwriter.ErrOutputLimitExceeded) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrOutputLimitExceeded, err)
+		}
+
+		// The count starts over on Reset.
+		actGzipWriter.Reset(io.Discard)
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+}
+
+func TestFlushN(t *testing.T) {
+	t.Parallel()
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+
+	// The first flush writes the header, and a sync marker.
+	n, err := actGzipWriter.FlushN()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != int64(actBuffer.Len()) {
+		t.Fatalf("expected %d bytes flushed, got %d", actBuffer.Len(), n)
+	}
+
+	// No pending data.
+	before := actBuffer.Len()
+	n, err = actGzipWriter.FlushN()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 0 || actBuffer.Len() != before {
+		t.Fatalf("expected no bytes flushed, got %d (%d bytes written)", n, actBuffer.Len()-before)
+	}
+
+	if _, err := actGzipWriter.Write(randomTestBytes(1000)); err != nil {
+		t.Fatal(err)
+	}
+	before = actBuffer.Len()
+	n, err = actGzipWriter.FlushN()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n == 0 || n != int64(actBuffer.Len()-before) {
+		t.Fatalf("expected %d bytes flushed, got %d", actBuffer.Len()-before, n)
+	}
+	if actGzipWriter.OutputBytes() != int64(actBuffer.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", actBuffer.Len(), actGzipWriter.OutputBytes())
+	}
+
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if actGzipWriter.OutputBytes() != int64(actBuffer.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", actBuffer.Len(), actGzipWriter.OutputBytes())
+	}
+	if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestWithText(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled %t", enabled), func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithText(enabled))
+			actGzipWriter.Name = "notes.txt"
+			for range 2 {
+				if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+					t.Fatal(err)
+				}
+				// FTEXT is bit 0 of the FLG byte, next to the other flags.
+				expFlags := byte(0x08)
+				if enabled {
+					expFlags |= 0x01
+				}
+				if actBuffer.Bytes()[3] != expFlags {
+					t.Fatalf("expected FLG %#02x, got %#02x", expFlags, actBuffer.Bytes()[3])
+				}
+				if _, err := gzipstreamwriter.DecompressAll(&actBuffer); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+
+				// The option is kept across Reset.
+				actBuffer.Reset()
+				actGzipWriter.Reset(&actBuffer)
+				actGzipWriter.Name = "notes.txt"
+			}
+		})
+	}
+}
+
+func TestOnMember(t *testing.T) {
+	t.Parallel()
+
+	type event struct {
+		Index, CompressedLen int
+		Checksum             uint32
+	}
+	var events []event
+	onMember := gzipstreamwriter.OnMember(func(index, compressedLen int, checksum uint32) {
+		events = append(events, event{index, compressedLen, checksum})
+	})
+
+	blob := compressStdlib(t, []byte("hello, world!"))
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, onMember)
+	n, err := actGzipWriter.WriteCompressed(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.NextMember(); err != nil {
+		t.Fatal(err)
+	}
+	firstMember := actBuffer.Len()
+	if _, err := actGzipWriter.Write([]byte("more data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := gzipstreamwriter.SplitMembers(actBuffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobChecksum := crc32.ChecksumIEEE([]byte("hello, world!"))
+	secondChecksum := crc32.ChecksumIEEE([]byte("more datahello, world!"))
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	expected := []event{
+		{Index: 0, CompressedLen: n, Checksum: blobChecksum},
+		{Index: 1, CompressedLen: firstMember, Checksum: blobChecksum},
+		// The header, and the flushed deflate stream, came before the blob.
+		{Index: 2, CompressedLen: events[2].CompressedLen, Checksum: blobChecksum},
+		{Index: 3, CompressedLen: len(members[1]), Checksum: secondChecksum},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Fatalf("TestOnMember() mismatch (-want +got):\n%s", diff)
+	}
+	if events[2].CompressedLen <= len(blob)-18 {
+		t.Fatalf("expected more than %d bytes for the blob, got %d", len(blob)-18, events[2].CompressedLen)
+	}
+
+	// The index starts over on Reset.
+	events = nil
+	actGzipWriter.Reset(io.Discard)
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Index != 0 {
+		t.Fatalf("expected a single event with index 0, got %v", events)
+	}
+}
+
+func TestNewGzipStreamWriterLevelHeader(t *testing.T) {
+	t.Parallel()
+
+	header := gzip.Header{
+		Name:    "café.txt",
+		Comment: "a comment",
+		Extra:   []byte{'A', 'B', 0, 0},
+		ModTime: time.Unix(1_700_000_000, 0),
+		OS:      3,
+	}
+
+	testcases := []struct {
+		note   string
+		level  int
+		header gzip.Header
+		err    error
+	}{
+		{note: "valid", level: gzipstreamwriter.BestSpeed, header: header},
+		{note: "invalid level", level: 10, header: header, err: gzipstreamwriter.ErrInvalidCompressionLevel},
+		{note: "non-Latin-1 name", level: gzipstreamwriter.BestSpeed, header: gzip.Header{Name: "日本"}, err: gzipstreamwriter.ErrHdrNonLatin1},
+		{note: "non-Latin-1 comment", level: gzipstreamwriter.BestSpeed, header: gzip.Header{Comment: "a\x00b"}, err: gzipstreamwriter.ErrHdrNonLatin1},
+		{note: "extra too large", level: gzipstreamwriter.BestSpeed, header: gzip.Header{Extra: make([]byte, 0x10000)}, err: gzipstreamwriter.ErrHdrExtaDataTooLarge},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevelHeader(&actBuffer, tc.level, tc.header)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				if actGzipWriter != nil {
+					t.Fatalf("expected no writer, got %v", actGzipWriter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := writeToBuffer(t, actGzipWriter, []byte("hello, world!")); err != nil {
+				t.Fatal(err)
+			}
+
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(tc.header, gzReader.Header); diff != "" {
+				t.Fatalf("TestNewGzipStreamWriterLevelHeader() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutputBytes(t *testing.T) {
+	t.Parallel()
+
+	first := bytes.Buffer{}
+	second := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&first)
+	actGzipWriter.Name = "data.bin"
+	if _, err := actGzipWriter.Write(randomTestBytes(10_000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, []byte("hello, world!\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The count carries over to the new writer.
+	if err := actGzipWriter.SetWriter(&second); err != nil {
+		t.Fatal(err)
+	}
+	if actGzipWriter.OutputBytes() != int64(first.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", first.Len(), actGzipWriter.OutputBytes())
+	}
+	if _, err := actGzipWriter.Write(randomTestBytes(5_000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if actGzipWriter.OutputBytes() != int64(first.Len()+second.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", first.Len()+second.Len(), actGzipWriter.OutputBytes())
+	}
+
+	// Reset starts the count over.
+	actGzipWriter.Reset(io.Discard)
+	if actGzipWriter.OutputBytes() != 0 {
+		t.Fatalf("expected 0 bytes written, got %d", actGzipWriter.OutputBytes())
+	}
+}
+
+func TestPrepareCompressor(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(50_000)
+	dict := randomTestBytes(1000)
+
+	testcases := []struct {
+		note string
+		dict []byte
+	}{
+		{note: "no dictionary"},
+		{note: "preset dictionary", dict: dict},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			expBuffer := bytes.Buffer{}
+			expGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevelDict(&expBuffer, gzipstreamwriter.BestSpeed, tc.dict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+				t.Fatal(err)
+			}
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevelDict(&actBuffer, gzipstreamwriter.BestSpeed, tc.dict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := actGzipWriter.PrepareCompressor(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if actBuffer.Len() != 0 || actGzipWriter.HeaderWritten() {
+				t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+			}
+			if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+				t.Fatalf("TestPrepareCompressor() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithoutTrailer(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(20_000)
+	blob := compressStdlib(t, []byte("hello, world!\n"))
+
+	write := func(t *testing.T, gzWriter *gzipstreamwriter.GzipStreamWriter) {
+		t.Helper()
+		if _, err := gzWriter.Write(input[:10_000]); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzWriter.NextMember(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gzWriter.WriteCompressed(blob); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gzWriter.Write(input[10_000:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	write(t, expGzipWriter)
+	if err := expGzipWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithoutTrailer())
+	write(t, actGzipWriter)
+	if err := actGzipWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	estimatedSize := actGzipWriter.EstimatedSize()
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if estimatedSize != int64(actBuffer.Len()) {
+		t.Fatalf("expected estimated size %d, got %d", actBuffer.Len(), estimatedSize)
+	}
+
+	// Only the last trailer is left out, and Digest returns its fields.
+	checksum, size := actGzipWriter.Digest()
+	trailer := binary.LittleEndian.AppendUint32(nil, checksum)
+	trailer = binary.LittleEndian.AppendUint32(trailer, size)
+	if diff := cmp.Diff(expBuffer.Bytes(), append(actBuffer.Bytes(), trailer...)); diff != "" {
+		t.Fatalf("TestWithoutTrailer() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithWriteBuffer(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(100_000)
+	blob := compressStdlib(t, []byte("hello, world!\n"))
+
+	// Writes of all sizes, around the buffer size, with blobs and flushes in
+	// between.
+	write := func(t *testing.T, gzWriter *gzipstreamwriter.GzipStreamWriter) {
+		t.Helper()
+		rest := input
+		for i := 0; len(rest) > 0; i++ {
+			n := min(len(rest), []int{1, 20, 255, 256, 257, 4000}[i%6])
+			if _, err := gzWriter.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+			switch i % 50 {
+			case 10:
+				if _, err := gzWriter.WriteCompressed(blob); err != nil {
+					t.Fatal(err)
+				}
+			case 20:
+				if err := gzWriter.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			case 30:
+				if err := gzWriter.NextMember(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	write(t, expGzipWriter)
+	expChecksum, expSize := expGzipWriter.Digest()
+	if err := expGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithWriteBuffer(256))
+	write(t, actGzipWriter)
+	actChecksum, actSize := actGzipWriter.Digest()
+	if actChecksum != expChecksum || actSize != expSize {
+		t.Fatalf("expected digest %#08x and size %d, got %#08x and %d", expChecksum, expSize, actChecksum, actSize)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+		t.Fatalf("TestWithWriteBuffer() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkWithWriteBuffer(b *testing.B) {
+	events := make([][]byte, 100_000)
+	input := randomTestBytes(20 * len(events))
+	for i := range events {
+		events[i] = input[i*20 : (i+1)*20]
+	}
+
+	for _, size := range []int{0, 4096} {
+		b.Run(fmt.Sprintf("buffer %d", size), func(b *testing.B) {
+			z := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.WithWriteBuffer(size))
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				z.Reset(io.Discard)
+				for _, event := range events {
+					if _, err := z.Write(event); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := z.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestResetDigest(t *testing.T) {
+	t.Parallel()
+
+	segments := [][]byte{randomTestBytes(10_000), []byte("hello, world!\n")}
+
+	for _, size := range []int{0, 4096} {
+		t.Run(fmt.Sprintf("write buffer %d", size), func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithoutTrailer(), gzipstreamwriter.WithWriteBuffer(size))
+			for i, segment := range segments {
+				if i > 0 {
+					if err := actGzipWriter.ResetDigest(); err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+				}
+				if _, err := actGzipWriter.Write(segment); err != nil {
+					t.Fatal(err)
+				}
+				checksum, length := actGzipWriter.Digest()
+				if checksum != crc32.ChecksumIEEE(segment) || length != uint32(len(segment)) {
+					t.Fatalf("segment %d: expected digest %#08x and size %d, got %#08x and %d", i, crc32.ChecksumIEEE(segment), len(segment), checksum, length)
+				}
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// With a trailer for the whole stream, the output is valid gzip.
+			input := slices.Concat(segments...)
+			output := binary.LittleEndian.AppendUint32(actBuffer.Bytes(), crc32.ChecksumIEEE(input))
+			output = binary.LittleEndian.AppendUint32(output, uint32(len(input)))
+			actual, err := gzipstreamwriter.DecompressAll(bytes.NewReader(output))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(input, actual); diff != "" {
+				t.Fatalf("TestResetDigest() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteMembers(t *testing.T) {
+	t.Parallel()
+
+	var blobs [][]byte
+	for i := range 5 {
+		blobs = append(blobs, compressStdlib(t, randomTestBytes(1000*(i+1))))
+	}
+	errProducer := errors.New("producer failed")
+
+	// blobSeq yields the blobs, and then err if it is not nil. It records how
+	// many values were consumed.
+	blobSeq := func(blobs [][]byte, err error, consumed *int) func(yield func([]byte, error) bool) {
+		return func(yield func([]byte, error) bool) {
+			for _, blob := range blobs {
+				*consumed++
+				if !yield(blob, nil) {
+					return
+				}
+			}
+			if err != nil {
+				*consumed++
+				if !yield(nil, err) {
+					return
+				}
+				// Values after an error must not be consumed.
+				*consumed++
+				yield(blobs[0], nil)
+			}
+		}
+	}
+
+	t.Run("all blobs", func(t *testing.T) {
+		t.Parallel()
+
+		expected, err := gzipstreamwriter.AssembleGolden(blobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		var consumed int
+		if err := actGzipWriter.WriteMembers(blobSeq(blobs, nil, &consumed)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWriteMembers() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("producer error", func(t *testing.T) {
+		t.Parallel()
+
+		expected, err := gzipstreamwriter.AssembleGolden(blobs[:2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		var consumed int
+		if err := actGzipWriter.WriteMembers(blobSeq(blobs[:2], errProducer, &consumed)); !errors.Is(err, errProducer) {
+			t.Fatalf("expected error %v, got %v", errProducer, err)
+		}
+		if consumed != 3 {
+			t.Fatalf("expected 3 values consumed, got %d", consumed)
+		}
+		// The error is not sticky, so the stream can still be finished.
+		if err := actGzipWriter.Err(); err != nil {
+			t.Fatalf("expected no sticky error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWriteMembers() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(io.Discard)
+		var consumed int
+		seq := blobSeq([][]byte{blobs[0], []byte("not a gzip blob"), blobs[1]}, nil, &consumed)
+		if err := actGzipWriter.WriteMembers(seq); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		if consumed != 2 {
+			t.Fatalf("expected 2 values consumed, got %d", consumed)
+		}
+	})
+}
+
+func TestLevels(t *testing.T) {
+	t.Parallel()
+
+	input := bytes.Repeat(randomTestBytes(5000), 4)
+	for level := gzipstreamwriter.HuffmanOnly; level <= gzipstreamwriter.BestCompression; level++ {
+		t.Run(fmt.Sprintf("level %d", level), func(t *testing.T) {
+			t.Parallel()
+
+			// The XFL byte matches the stdlib's.
+			expBuffer := bytes.Buffer{}
+			expGzipWriter, err := gzip.NewWriterLevel(&expBuffer, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := expGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, level)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.Write(input[:len(input)/2]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, input[len(input)/2:])); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if actBuffer.Bytes()[8] != expBuffer.Bytes()[8] {
+				t.Fatalf("expected XFL %d, got %d", expBuffer.Bytes()[8], actBuffer.Bytes()[8])
+			}
+
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(input, actual); diff != "" {
+				t.Fatalf("TestLevels() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStrictHeaders(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("hello, world!\n")
+	blob := compressStdlib(t, content)
+	blob[3] |= 0x20
+
+	testcases := []struct {
+		note    string
+		enabled bool
+		err     error
+	}{
+		{note: "disabled", enabled: false},
+		{note: "enabled", enabled: true, err: gzipstreamwriter.ErrBlob},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.StrictHeaders(tc.enabled))
+			if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, tc.err) {
+				t.Fatalf("WriteCompressed: expected error %v, got %v", tc.err, err)
+			}
+			if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); !errors.Is(err, tc.err) {
+				t.Fatalf("WriteCompressedReader: expected error %v, got %v", tc.err, err)
+			}
+			if _, err := actGzipWriter.WriteCompressedFrom(bytes.NewReader(blob)); !errors.Is(err, tc.err) {
+				t.Fatalf("WriteCompressedFrom: expected error %v, got %v", tc.err, err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			// Rejected blobs leave nothing behind. Accepted ones are spliced
+			// in, without the reserved bits, which are not copied.
+			expected := bytes.Repeat(content, 3)
+			if tc.err != nil {
+				expected = []byte{}
+			}
+			actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestStrictHeaders() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWarnOnMixedLevels(t *testing.T) {
+	t.Parallel()
+
+	compress := func(t *testing.T, level int) []byte {
+		t.Helper()
+		blob, err := gzipstreamwriter.Compress(randomTestBytes(1000+level), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+
+	testcases := []struct {
+		note      string
+		level     int
+		blobLevel int
+		enabled   bool
+		err       error
+	}{
+		{
+			note:      "same level",
+			level:     gzipstreamwriter.BestCompression,
+			blobLevel: gzipstreamwriter.BestCompression,
+			enabled:   true,
+		},
+		{
+			note:      "levels that share an XFL",
+			level:     gzipstreamwriter.DefaultCompression,
+			blobLevel: 5,
+			enabled:   true,
+		},
+		{
+			note:      "mixed levels",
+			level:     gzipstreamwriter.BestSpeed,
+			blobLevel: gzipstreamwriter.BestCompression,
+			enabled:   true,
+			err:       gzipstreamwriter.ErrMixedLevels,
+		},
+		{
+			note:      "stored blob",
+			level:     gzipstreamwriter.BestCompression,
+			blobLevel: gzipstreamwriter.NoCompression,
+			enabled:   true,
+			err:       gzipstreamwriter.ErrMixedLevels,
+		},
+		{
+			note:      "disabled",
+			level:     gzipstreamwriter.BestSpeed,
+			blobLevel: gzipstreamwriter.BestCompression,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterLevel(&actBuffer, tc.level, gzipstreamwriter.WarnOnMixedLevels(tc.enabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob := compress(t, tc.blobLevel)
+			n, err := actGzipWriter.WriteCompressed(blob)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if tc.err != nil && n != 0 {
+				t.Fatalf("expected 0 bytes written, got %d", n)
+			}
+			// The streaming variants check the same header.
+			if err := actGzipWriter.WriteCompressedReader(bytes.NewReader(blob), len(blob)); !errors.Is(err, tc.err) {
+				t.Fatalf("WriteCompressedReader: expected error %v, got %v", tc.err, err)
+			}
+			if _, err := actGzipWriter.WriteCompressedFrom(bytes.NewReader(blob)); !errors.Is(err, tc.err) {
+				t.Fatalf("WriteCompressedFrom: expected error %v, got %v", tc.err, err)
+			}
+			if tc.err != nil && actBuffer.Len() != 0 {
+				t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+			}
+			// The error is not sticky.
+			if _, err := actGzipWriter.WriteCompressed(compress(t, tc.level)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithLazyHeaderOnFlush(t *testing.T) {
+	t.Parallel()
+
+	t.Run("flush before write", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithLazyHeaderOnFlush(true))
+		if err := actGzipWriter.Flush(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		n, err := actGzipWriter.FlushN()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n != 0 || actBuffer.Len() != 0 {
+			t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+		}
+	})
+
+	t.Run("output matches", func(t *testing.T) {
+		t.Parallel()
+
+		input := randomTestBytes(2000)
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		if _, err := expGzipWriter.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := expGzipWriter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := expGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The flushes before the first write leave no trace in the output.
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithLazyHeaderOnFlush(true))
+		for range 3 {
+			if err := actGzipWriter.Flush(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Flush(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWithLazyHeaderOnFlush() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithLazyHeaderOnFlush(false))
+		if err := actGzipWriter.Flush(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actBuffer.Len() == 0 {
+			t.Fatal("expected the header and a sync block, got no output")
+		}
+	})
+}
+
+func TestCloseAll(t *testing.T) {
+	t.Parallel()
+
+	t.Run("closes the underlying writer", func(t *testing.T) {
+		t.Parallel()
+
+		input := randomTestBytes(1000)
+		// The underlying writer is closed through the retry wrapper.
+		dst := &countingWriteCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(dst, gzipstreamwriter.WithWriteRetry(gzipstreamwriter.WriteRetryPolicy{MaxAttempts: 2}))
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.CloseAll(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if dst.closes != 1 {
+			t.Fatalf("expected 1 close, got %d", dst.closes)
+		}
+		actual, err := gzipstreamwriter.DecompressAll(&dst.Buffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(input, actual); diff != "" {
+			t.Fatalf("TestCloseAll() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not a closer", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&bytes.Buffer{})
+		if err := actGzipWriter.CloseAll(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("close error", func(t *testing.T) {
+		t.Parallel()
+
+		dst := &failingCloser{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(dst)
+		if err := actGzipWriter.CloseAll(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected errTestWrite, got %v", err)
+		}
+	})
+
+	t.Run("trailer write fails", func(t *testing.T) {
+		t.Parallel()
+
+		dst := &failingCloser{failWrites: true}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(dst)
+		if err := actGzipWriter.CloseAll(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected errTestWrite, got %v", err)
+		}
+		if dst.closes != 0 {
+			t.Fatalf("expected no close, got %d", dst.closes)
+		}
+	})
+}
+
+func TestWithExtendedTrailer(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(3000)
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithExtendedTrailer())
+	if _, err := actGzipWriter.Write(input[:1000]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, input[1000:])); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := actGzipWriter.Flush(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	estimate := actGzipWriter.EstimatedSize()
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := actBuffer.Bytes()
+	if estimate != int64(len(output)) {
+		t.Fatalf("expected estimated size %d, got %d", len(output), estimate)
+	}
+	trailer := output[len(output)-12:]
+	if checksum := binary.LittleEndian.Uint32(trailer[:4]); checksum != crc32.ChecksumIEEE(input) {
+		t.Fatalf("expected CRC32 %#08x, got %#08x", crc32.ChecksumIEEE(input), checksum)
+	}
+	if size := binary.LittleEndian.Uint64(trailer[4:]); size != uint64(len(input)) {
+		t.Fatalf("expected size %d, got %d", len(input), size)
+	}
+
+	// The DEFLATE stream is unchanged, and ends right before the trailer.
+	actual, err := io.ReadAll(flate.NewReader(bytes.NewReader(output[10 : len(output)-12])))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if diff := cmp.Diff(input, actual); diff != "" {
+		t.Fatalf("TestWithExtendedTrailer() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteCompressedFirstUsesWriterHeader(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(1000)
+	blobBuffer := bytes.Buffer{}
+	blobWriter := gzip.NewWriter(&blobBuffer)
+	blobWriter.Name = "in.gz"
+	blobWriter.Comment = "blob comment"
+	blobWriter.ModTime = time.Unix(1_700_000_000, 0)
+	if _, err := blobWriter.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := blobWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		note   string
+		header gzip.Header
+	}{
+		{
+			note:   "configured header",
+			header: gzip.Header{Name: "out.gz", OS: 255},
+		},
+		{
+			note:   "default header",
+			header: gzip.Header{OS: 255},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			actGzipWriter.Header = tc.header
+			if _, err := actGzipWriter.WriteCompressed(blobBuffer.Bytes()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// None of the blob's header fields leak into the output.
+			if diff := cmp.Diff(tc.header, gzReader.Header); diff != "" {
+				t.Fatalf("TestWriteCompressedFirstUsesWriterHeader() mismatch (-want +got):\n%s", diff)
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(input, actual); diff != "" {
+				t.Fatalf("TestWriteCompressedFirstUsesWriterHeader() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResetKeepHeader(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(1000)
+	var buffer bytes.Buffer
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&buffer)
+	actGzipWriter.Name = "events.log"
+	actGzipWriter.Comment = "pooled"
+	if err := actGzipWriter.AddExtraSubfield('E', 'V', []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	expHeader := actGzipWriter.Header
+	extra := &actGzipWriter.Extra[0]
+
+	for i := range 3 {
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		gzReader, err := gzip.NewReader(&buffer)
+		if err != nil {
+			t.Fatalf("stream %d: expected no error, got %v", i, err)
+		}
+		if diff := cmp.Diff(expHeader, gzReader.Header); diff != "" {
+			t.Fatalf("TestResetKeepHeader() mismatch (-want +got):\n%s", diff)
+		}
+		actual, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatalf("stream %d: expected no error, got %v", i, err)
+		}
+		if diff := cmp.Diff(input, actual); diff != "" {
+			t.Fatalf("TestResetKeepHeader() mismatch (-want +got):\n%s", diff)
+		}
+
+		buffer.Reset()
+		actGzipWriter.ResetKeepHeader(&buffer)
+		if &actGzipWriter.Extra[0] != extra {
+			t.Fatalf("stream %d: expected the Extra field to keep its backing array", i)
+		}
+	}
+}
+
+func TestWriteCompressedStoredBlobs(t *testing.T) {
+	t.Parallel()
+
+	compressStored := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		gzWriter, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gzWriter.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	testcases := []struct {
+		note string
+		size int
+	}{
+		{note: "empty", size: 0},
+		{note: "small", size: 100},
+		{note: "one full block", size: 0xffff},
+		{note: "several blocks", size: 200_000},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			data := randomTestBytes(tc.size)
+			blob := compressStored(t, data)
+
+			// The DEFLATE payload sits between the 10-byte header and the
+			// 8-byte trailer, and starts with a stored block (BTYPE 00),
+			// unless there is no data for one.
+			content, checksum, isize, err := gzipstreamwriter.TrimBlob(blob)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(blob[10:len(blob)-8], content); diff != "" {
+				t.Fatalf("TestWriteCompressedStoredBlobs() mismatch (-want +got):\n%s", diff)
+			}
+			if btype := (content[0] >> 1) & 0x3; tc.size > 0 && btype != 0 {
+				t.Fatalf("expected a stored block, got BTYPE %d", btype)
+			}
+			if checksum != crc32.ChecksumIEEE(data) || int(isize) != len(data) {
+				t.Fatalf("expected trailer %#08x/%d, got %#08x/%d", crc32.ChecksumIEEE(data), len(data), checksum, isize)
+			}
+
+			// Spliced between writes, and next to a compressed blob, the
+			// stored data is passed through as-is.
+			before := randomTestBytes(1000)
+			after := []byte(strings.Repeat("after the stored blob ", 50))
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			if _, err := actGzipWriter.Write(before); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, after)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Contains(actBuffer.Bytes(), data[:min(len(data), 0xffff)]) {
+				t.Fatal("expected the stored data to appear unchanged in the output")
+			}
+
+			expected := slices.Concat(before, data, after)
+			output := actBuffer.Bytes()
+			trailer := output[len(output)-8:]
+			if actChecksum := binary.LittleEndian.Uint32(trailer[:4]); actChecksum != crc32.ChecksumIEEE(expected) {
+				t.Fatalf("expected CRC32 %#08x, got %#08x", crc32.ChecksumIEEE(expected), actChecksum)
+			}
+			actual, err := gzipstreamwriter.DecompressAll(bytes.NewReader(output))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestWriteCompressedStoredBlobs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestCombinedCRCProperty checks the core invariant behind WriteCompressed: for
+// any sequence of members, the CRC32 that Close writes from the combined
+// per-member checksums equals the CRC32 of all of the decompressed data.
+// The cases are random, but seeded, so a failure can be replayed.
+func TestCombinedCRCProperty(t *testing.T) {
+	t.Parallel()
+
+	levels := []int{gzipstreamwriter.NoCompression, gzipstreamwriter.BestSpeed, gzipstreamwriter.DefaultCompression, gzipstreamwriter.BestCompression, gzipstreamwriter.HuffmanOnly}
+	for seed := range uint64(50) {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			t.Parallel()
+
+			r := rand.New(rand.NewPCG(seed, 0))
+			expected := []byte{}
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+			for range 1 + r.IntN(8) {
+				// Mix empty, tiny, and multi-block members, and both
+				// incompressible and repetitive data.
+				var member []byte
+				switch r.IntN(4) {
+				case 0:
+				case 1:
+					member = make([]byte, 1+r.IntN(16))
+				case 2:
+					member = make([]byte, r.IntN(100_000))
+				default:
+					member = bytes.Repeat([]byte{byte(r.Uint32())}, r.IntN(100_000))
+				}
+				if r.IntN(2) == 0 {
+					for i := range member {
+						member[i] = byte(r.Uint32())
+					}
+				}
+				blob, err := gzipstreamwriter.Compress(member, levels[r.IntN(len(levels))])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				expected = append(expected, member...)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			output := actBuffer.Bytes()
+			trailer := output[len(output)-8:]
+			actual, err := io.ReadAll(flate.NewReader(bytes.NewReader(output[10 : len(output)-8])))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestCombinedCRCProperty() mismatch (-want +got):\n%s", diff)
+			}
+			if checksum := binary.LittleEndian.Uint32(trailer[:4]); checksum != crc32.ChecksumIEEE(actual) {
+				t.Fatalf("expected CRC32 %#08x, got %#08x", crc32.ChecksumIEEE(actual), checksum)
+			}
+			if isize := binary.LittleEndian.Uint32(trailer[4:]); isize != uint32(len(actual)) {
+				t.Fatalf("expected ISIZE %d, got %d", len(actual), isize)
+			}
+		})
+	}
+}
+
+func TestWithOSFromRuntime(t *testing.T) {
+	t.Parallel()
+
+	expOS := byte(255)
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "netbsd", "openbsd":
+		expOS = 3
+	case "windows":
+		expOS = 11
+	}
+
+	testcases := []struct {
+		note  string
+		opts  []gzipstreamwriter.Option
+		expOS byte
+	}{
+		{note: "default", expOS: 255},
+		{note: "from runtime", opts: []gzipstreamwriter.Option{gzipstreamwriter.WithOSFromRuntime()}, expOS: expOS},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, tc.opts...)
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if actBuffer.Bytes()[9] != tc.expOS {
+				t.Fatalf("expected OS %d, got %d", tc.expOS, actBuffer.Bytes()[9])
+			}
+
+			// The choice is kept across Reset.
+			actBuffer.Reset()
+			actGzipWriter.Reset(&actBuffer)
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if actBuffer.Bytes()[9] != tc.expOS {
+				t.Fatalf("expected OS %d after Reset, got %d", tc.expOS, actBuffer.Bytes()[9])
+			}
+		})
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note string
+		opts []gzipstreamwriter.Option
+	}{
+		{note: "default"},
+		{note: "write buffer", opts: []gzipstreamwriter.Option{gzipstreamwriter.WithWriteBuffer(64 * 1024)}},
+		{note: "auto level", opts: []gzipstreamwriter.Option{gzipstreamwriter.WithAutoLevel(1 << 20)}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			pool := sync.Pool{New: func() any { return new(bytes.Buffer) }}
+			var expected []byte
+			actBuffer := bytes.Buffer{}
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, tc.opts...)
+			for i := range 10 {
+				b, ok := pool.Get().(*bytes.Buffer)
+				if !ok {
+					t.Fatal("expected a *bytes.Buffer from the pool")
+				}
+				payload := randomTestBytes(100 + i)
+				b.Write(payload)
+				n, err := actGzipWriter.WriteBuffer(b)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if n != len(payload) || b.Len() != len(payload) {
+					t.Fatalf("expected %d bytes written and left in the buffer, got %d and %d", len(payload), n, b.Len())
+				}
+				expected = append(expected, payload...)
+
+				// Scribble over the buffer before returning it, so that any
+				// data the writer kept a reference to would be corrupted.
+				for j := range b.Bytes() {
+					b.Bytes()[j] = 0xff
+				}
+				b.Reset()
+				pool.Put(b)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestWriteBuffer() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithBlobFilter(t *testing.T) {
+	t.Parallel()
+
+	// Only accept blobs whose header names a trusted producer.
+	filter := func(blob []byte) error {
+		header, err := gzipstreamwriter.ParseBlobHeader(blob)
+		if err != nil {
+			return err
+		}
+		if header.Name != "trusted" {
+			return errTestWrite
+		}
+		return nil
+	}
+	makeBlob := func(t *testing.T, name string, data []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		gzWriter := gzip.NewWriter(&buf)
+		gzWriter.Name = name
+		if _, err := gzWriter.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	trusted := randomTestBytes(1000)
+	untrusted := randomTestBytes(2000)
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithBlobFilter(filter))
+	if _, err := actGzipWriter.WriteCompressed(makeBlob(t, "trusted", trusted)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	written := actBuffer.Len()
+	n, err := actGzipWriter.WriteCompressed(makeBlob(t, "other", untrusted))
+	if err != errTestWrite { //nolint:errorlint // The error must be returned verbatim.
+		t.Fatalf("expected error %v, got %v", errTestWrite, err)
+	}
+	if n != 0 || actBuffer.Len() != written {
+		t.Fatalf("expected nothing written, got %d bytes", actBuffer.Len()-written)
+	}
+	// The error is not sticky.
+	if _, err := actGzipWriter.WriteCompressed(makeBlob(t, "trusted", trusted)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if diff := cmp.Diff(slices.Concat(trusted, trusted), actual); diff != "" {
+		t.Fatalf("TestWithBlobFilter() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithSeamlessBlobs(t *testing.T) {
+	t.Parallel()
+
+	text := func(s string) []byte {
+		return []byte(strings.Repeat(s, 200))
+	}
+	a, b, c := text("the first raw write. "), text("the blob in the middle. "), text("the second raw write. ")
+
+	type step struct {
+		data       []byte
+		compressed bool
+	}
+	run := func(t *testing.T, steps []step, opts ...gzipstreamwriter.Option) []byte {
+		t.Helper()
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, opts...)
+		for _, s := range steps {
+			var err error
+			if s.compressed {
+				_, err = actGzipWriter.WriteCompressed(compressStdlib(t, s.data))
+			} else {
+				_, err = actGzipWriter.Write(s.data)
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return actBuffer.Bytes()
+	}
+
+	t.Run("blob between writes", func(t *testing.T) {
+		t.Parallel()
+
+		// The output is the same as if the blob's data had been written.
+		expected := run(t, []step{{data: a}, {data: b}, {data: c}})
+		actual := run(t, []step{{data: a}, {data: b, compressed: true}, {data: c}}, gzipstreamwriter.WithSeamlessBlobs())
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("TestWithSeamlessBlobs() mismatch (-want +got):\n%s", diff)
+		}
+		spliced := run(t, []step{{data: a}, {data: b, compressed: true}, {data: c}})
+		if len(actual) >= len(spliced) {
+			t.Fatalf("expected less than the %d bytes of spliced output, got %d", len(spliced), len(actual))
+		}
+	})
+
+	t.Run("leading blob is spliced", func(t *testing.T) {
+		t.Parallel()
+
+		steps := []step{{data: b, compressed: true}, {data: a}}
+		expected := run(t, steps)
+		actual := run(t, steps, gzipstreamwriter.WithSeamlessBlobs())
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("TestWithSeamlessBlobs() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("corrupt blob", func(t *testing.T) {
+		t.Parallel()
+
+		blob := compressStdlib(t, b)
+		blob[len(blob)-8] ^= 0xff // Break the CRC32.
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer, gzipstreamwriter.WithSeamlessBlobs())
+		if _, err := actGzipWriter.Write(a); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		if _, err := actGzipWriter.Write(c); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		actual, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(slices.Concat(a, c), actual); diff != "" {
+			t.Fatalf("TestWithSeamlessBlobs() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note  string
+		dst   io.Writer
+		opts  []gzipstreamwriter.Option
+		setup func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter)
+		err   error
+	}{
+		{
+			note:  "new writer",
+			dst:   &bytes.Buffer{},
+			setup: func(*testing.T, *gzipstreamwriter.GzipStreamWriter) {},
+		},
+		{
+			note: "after writes and blobs",
+			dst:  &bytes.Buffer{},
+			opts: []gzipstreamwriter.Option{gzipstreamwriter.WithWriteBuffer(4096), gzipstreamwriter.WithMemberAlignment(512)},
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				for range 4 {
+					if _, err := z.Write(randomTestBytes(1024)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := z.WriteCompressed(compressStdlib(t, randomTestBytes(100))); err != nil {
+					t.Fatal(err)
+				}
+				if err := z.NextMember(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			note: "closed",
+			dst:  &bytes.Buffer{},
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				if err := z.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: gzipstreamwriter.ErrClosed,
+		},
+		{
+			note: "failed write",
+			dst:  &failingWriter{},
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				_ = z.Flush()
+			},
+			err: errTestWrite,
+		},
+		{
+			note: "aborted",
+			dst:  &bytes.Buffer{},
+			setup: func(t *testing.T, z *gzipstreamwriter.GzipStreamWriter) {
+				t.Helper()
+				if err := z.Abort(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: gzipstreamwriter.ErrAborted,
+		},
+		{
+			note:  "no underlying writer",
+			dst:   nil,
+			setup: func(*testing.T, *gzipstreamwriter.GzipStreamWriter) {},
+			err:   gzipstreamwriter.ErrInvalidState,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(tc.dst, tc.opts...)
+			tc.setup(t, actGzipWriter)
+
+			// Validate does not change the writer.
+			before := actGzipWriter.String()
+			if err := actGzipWriter.Validate(); !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if after := actGzipWriter.String(); after != before {
+				t.Fatalf("expected state %s, got %s", before, after)
+			}
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Helper functions
 // ---------------------------------------------------------------------------
 
+var errTestWrite = errors.New("test: write failed")
+
+// failingWriter accepts the first okWrites calls to Write, and fails all calls after that.
+type failingWriter struct {
+	okWrites int
+	writes   int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	fw.writes++
+	if fw.writes > fw.okWrites {
+		return 0, errTestWrite
+	}
+	return len(p), nil
+}
+
+// countingWriteCloser is a bytes.Buffer that counts calls to Write and Close.
+type countingWriteCloser struct {
+	bytes.Buffer
+	writes int
+	closes int
+}
+
+func (cw *countingWriteCloser) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+func (cw *countingWriteCloser) Close() error {
+	cw.closes++
+	return nil
+}
+
 func writeToBuffer(t *testing.T, gzWriter io.WriteCloser, data []byte) (int, error) {
 	t.Helper()
 	var n int
@@ -246,3 +4002,35 @@ func decompressGzipBuffer(t *testing.T, gzReader *gzip.Reader, buffer *bytes.Buf
 	}
 	return io.ReadAll(gzReader)
 }
+
+// decompressDeflateDict decompresses the DEFLATE payload of a gzip stream
+// with a bare 10-byte header, using a preset dictionary.
+func decompressDeflateDict(t *testing.T, stream []byte, dict []byte) []byte {
+	t.Helper()
+	reader := flate.NewReaderDict(bytes.NewReader(stream[10:]), dict)
+	defer reader.Close() //nolint:errcheck
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// failingCloser is an io.WriteCloser whose Close always fails, and whose
+// writes fail if failWrites is set.
+type failingCloser struct {
+	failWrites bool
+	closes     int
+}
+
+func (fc *failingCloser) Write(p []byte) (int, error) {
+	if fc.failWrites {
+		return 0, errTestWrite
+	}
+	return len(p), nil
+}
+
+func (fc *failingCloser) Close() error {
+	fc.closes++
+	return errTestWrite
+}
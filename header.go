@@ -0,0 +1,119 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"time"
+)
+
+// BlobHeader is the parsed header of a gzip blob (or the first member of a
+// gzip stream).
+type BlobHeader struct {
+	gzip.Header
+	// XFL is the header's extra flags byte, which [gzip.Header] does not
+	// expose. Writers usually set it from the compression level.
+	XFL byte
+	// Length is the size of the encoded header in bytes, including any
+	// Extra, Name, Comment, and header CRC fields.
+	Length int
+}
+
+// ParseBlobHeader parses the gzip header at the start of p. Only the header
+// has to be present, p may be cut off anywhere after it.
+//
+// Name and Comment are decoded from Latin-1 to UTF-8, and a zero MTIME field
+// results in a zero ModTime, the same as with [gzip.Reader].
+// Malformed headers return an error wrapping ErrBlob, like getHeaderLength.
+func ParseBlobHeader(p []byte) (BlobHeader, error) {
+	headerLength, err := getHeaderLength(p)
+	if err != nil {
+		return BlobHeader{}, err
+	}
+
+	h := BlobHeader{
+		Header: gzip.Header{
+			OS: p[9],
+		},
+		XFL:    p[8],
+		Length: headerLength,
+	}
+	if mtime := binary.LittleEndian.Uint32(p[4:8]); mtime > 0 {
+		h.ModTime = time.Unix(int64(mtime), 0)
+	}
+
+	// The field lengths were already checked by getHeaderLength.
+	flag := p[3]
+	fields := p[10:headerLength]
+	if flag&flagExtra != 0 {
+		extraLength := int(binary.LittleEndian.Uint16(fields[:2]))
+		h.Extra = slices.Clone(fields[2 : 2+extraLength])
+		fields = fields[2+extraLength:]
+	}
+	if flag&flagName != 0 {
+		h.Name, fields = latin1String(fields)
+	}
+	if flag&flagComment != 0 {
+		h.Comment, _ = latin1String(fields)
+	}
+	return h, nil
+}
+
+// latin1String decodes the NUL-terminated Latin-1 string at the start of p,
+// and returns it as UTF-8, along with the rest of p after the terminator.
+func latin1String(p []byte) (string, []byte) {
+	end := bytes.IndexByte(p, 0)
+	runes := make([]rune, end)
+	for i, b := range p[:end] {
+		runes[i] = rune(b)
+	}
+	return string(runes), p[end+1:]
+}
+
+// NewGzipStreamWriterFromHeader creates a new GzipStreamWriter with the
+// default compression level, whose header is a copy of the header at the
+// start of src, as parsed by ParseBlobHeader. The Name, Comment, Extra, OS,
+// ModTime, and XFL fields are all carried over, so that re-packed output
+// matches the source's header byte-for-byte. A header CRC field is not.
+//
+// The XFL byte is copied as-is, even though it may not describe the level
+// the new writer compresses at. It takes precedence over WithXFL.
+func NewGzipStreamWriterFromHeader(w io.Writer, src []byte, opts ...Option) (*GzipStreamWriter, error) {
+	h, err := ParseBlobHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	z := NewGzipStreamWriter(w, append(slices.Clip(opts), WithXFL(h.XFL))...)
+	z.Header = h.Header
+	return z, nil
+}
+
+// RewriteBlobHeader returns a copy of the gzip blob p with its header
+// replaced by a new one built from h, without recompressing anything. The
+// DEFLATE payload and trailer are kept as they are. This is for normalizing
+// the Name, Comment, ModTime, and other metadata of blobs before storing them.
+//
+// The XFL byte and FTEXT flag describe the compressed data, so they are
+// copied from the old header. A header CRC field is not written.
+// If Name or Comment are not valid Latin-1, ErrHdrNonLatin1 is returned, and
+// a malformed blob returns an error wrapping ErrBlob.
+func RewriteBlobHeader(p []byte, h gzip.Header) ([]byte, error) {
+	if _, err := getDeflateSlice(p); err != nil {
+		return nil, err
+	}
+	headerLength, err := getHeaderLength(p)
+	if err != nil {
+		return nil, err
+	}
+	header, err := buildHeader(h, p[8], p[3]&flagText != 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, p[headerLength:]...), nil
+}
@@ -0,0 +1,251 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestParseBlobHeader(t *testing.T) {
+	t.Parallel()
+
+	header := gzip.Header{
+		Name:    "café.txt",
+		Comment: "a comment",
+		Extra:   []byte{'A', 'B', 2, 0, 'x', 'y'},
+		ModTime: time.Unix(1_700_000_000, 0),
+		OS:      3,
+	}
+	buffer := bytes.Buffer{}
+	gzWriter, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzWriter.Header = header
+	if _, err := gzWriter.Write([]byte("hello, world!\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := gzipstreamwriter.ParseBlobHeader(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := gzipstreamwriter.BlobHeader{
+		Header: header,
+		XFL:    2,
+		Length: 10 + 2 + 6 + len("caf\xe9.txt") + 1 + len("a comment") + 1,
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf("TestParseBlobHeader() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("header only", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := gzipstreamwriter.ParseBlobHeader(buffer.Bytes()[:expected.Length])
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(expected, actual); diff != "" {
+			t.Fatalf("TestParseBlobHeader() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("truncated header", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.ParseBlobHeader(buffer.Bytes()[:expected.Length-1])
+		if !errors.Is(err, gzipstreamwriter.ErrTruncatedHeader) {
+			t.Fatalf("expected ErrTruncatedHeader, got %v", err)
+		}
+	})
+
+	t.Run("bad magic bytes", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.ParseBlobHeader([]byte("not a gzip blob"))
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
+
+func TestNewGzipStreamWriterFromHeader(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		header gzip.Header
+		level  int
+	}{
+		{name: "empty header", level: gzip.DefaultCompression},
+		{
+			name: "all fields",
+			header: gzip.Header{
+				Name:    "café.txt",
+				Comment: "a comment",
+				Extra:   []byte{'A', 'B', 2, 0, 'x', 'y'},
+				ModTime: time.Unix(1_700_000_000, 0),
+				OS:      3,
+			},
+			level: gzip.BestSpeed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := bytes.Buffer{}
+			gzWriter, err := gzip.NewWriterLevel(&source, tc.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gzWriter.Header = tc.header
+			if _, err := gzWriter.Write([]byte("hello, world!\n")); err != nil {
+				t.Fatal(err)
+			}
+			if err := gzWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			output := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewGzipStreamWriterFromHeader(&output, source.Bytes())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.WriteCompressed(source.Bytes()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			expected, err := gzipstreamwriter.ParseBlobHeader(source.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := gzipstreamwriter.ParseBlobHeader(output.Bytes())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestNewGzipStreamWriterFromHeader() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(source.Bytes()[:expected.Length], output.Bytes()[:actual.Length]); diff != "" {
+				t.Fatalf("TestNewGzipStreamWriterFromHeader() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("invalid source", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.NewGzipStreamWriterFromHeader(&bytes.Buffer{}, []byte{0x1f})
+		if !errors.Is(err, gzipstreamwriter.ErrTruncatedHeader) {
+			t.Fatalf("expected ErrTruncatedHeader, got %v", err)
+		}
+	})
+}
+
+func TestRewriteBlobHeader(t *testing.T) {
+	t.Parallel()
+
+	source := bytes.Buffer{}
+	gzWriter, err := gzip.NewWriterLevel(&source, gzip.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzWriter.Name = "old-name.txt"
+	gzWriter.Comment = "an old comment"
+	gzWriter.ModTime = time.Unix(1_600_000_000, 0)
+	if _, err := gzWriter.Write([]byte("hello, world!\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blob := source.Bytes()
+	original := bytes.Clone(blob)
+
+	testCases := []struct {
+		name   string
+		header gzip.Header
+	}{
+		{name: "empty header"},
+		{
+			name: "all fields",
+			header: gzip.Header{
+				Name:    "café.txt",
+				Comment: "a new comment",
+				Extra:   []byte{'A', 'B', 2, 0, 'x', 'y'},
+				ModTime: time.Unix(1_700_000_000, 0),
+				OS:      3,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := gzipstreamwriter.RewriteBlobHeader(blob, tc.header)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			header, err := gzipstreamwriter.ParseBlobHeader(actual)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// The XFL byte is kept from the old header.
+			if header.XFL != 4 {
+				t.Fatalf("expected XFL 4, got %d", header.XFL)
+			}
+			if diff := cmp.Diff(tc.header, header.Header); diff != "" {
+				t.Fatalf("TestRewriteBlobHeader() mismatch (-want +got):\n%s", diff)
+			}
+
+			gzReader, err := gzip.NewReader(bytes.NewReader(actual))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			data, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(data) != "hello, world!\n" {
+				t.Fatalf("expected %q, got %q", "hello, world!\n", data)
+			}
+			if !bytes.Equal(blob, original) {
+				t.Fatalf("expected source blob to be unchanged")
+			}
+		})
+	}
+
+	t.Run("non-Latin-1 name", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.RewriteBlobHeader(blob, gzip.Header{Name: "日本語"})
+		if !errors.Is(err, gzipstreamwriter.ErrHdrNonLatin1) {
+			t.Fatalf("expected ErrHdrNonLatin1, got %v", err)
+		}
+	})
+
+	t.Run("truncated blob", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := gzipstreamwriter.RewriteBlobHeader(blob[:20], gzip.Header{})
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
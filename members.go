@@ -0,0 +1,288 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// emptyDeflateStreams are the encodings of an empty DEFLATE stream that
+// encoders are known to write: a final fixed Huffman block with only an
+// end-of-block code, and a final empty stored block.
+var emptyDeflateStreams = [][]byte{
+	emptyFinalBlock,
+	{0x01, 0x00, 0x00, 0xff, 0xff},
+}
+
+// CountMembers counts the gzip members in the stream p, without decompressing them.
+//
+// Gzip members are not length-prefixed, so each member is located by:
+//   - Parsing its header (see getHeaderLength).
+//   - Walking the DEFLATE blocks that follow, up to the end of the block with
+//     the BFINAL bit set (see scanDeflate). This decodes the Huffman symbols,
+//     but does not produce any output, or compute any checksums.
+//   - Skipping the 8-byte CRC32/ISIZE trailer.
+//
+// The next member, if any, begins immediately after the trailer. Every member,
+// including the last one, must have its complete trailer present.
+// An empty p contains zero members.
+func CountMembers(p []byte) (int, error) {
+	count := 0
+	for len(p) > 0 {
+		memberLength, err := getMemberLength(p)
+		if err != nil {
+			return count, fmt.Errorf("member %d: %w", count, err)
+		}
+		p = p[memberLength:]
+		count++
+	}
+	return count, nil
+}
+
+// getMemberLength returns the length of the complete gzip member at the start of p.
+func getMemberLength(p []byte) (int, error) {
+	headerLength, err := getHeaderLength(p)
+	if err != nil {
+		return 0, err
+	}
+	scan, err := scanDeflate(p[headerLength:])
+	if err != nil {
+		return 0, err
+	}
+	memberLength := headerLength + scan.length + 8
+	if len(p) < memberLength {
+		return 0, fmt.Errorf("%w: truncated trailer", ErrBlob)
+	}
+	return memberLength, nil
+}
+
+// SplitMembers splits the stream p into its gzip members, each of which is a
+// standalone, single-member gzip blob that can be passed to WriteCompressed,
+// or decompressed on its own. Members are located the same way as in
+// CountMembers. The returned blobs are subslices of p, so no data is copied.
+//
+// Note that blobs written by a GzipStreamWriter are spliced into a single
+// member, so splitting its output yields one blob per member (as started by
+// NextMember or FlushMember), not one blob per WriteCompressed call.
+// A malformed member returns an error wrapping ErrBlob.
+func SplitMembers(p []byte) ([][]byte, error) {
+	var members [][]byte
+	for len(p) > 0 {
+		memberLength, err := getMemberLength(p)
+		if err != nil {
+			return nil, fmt.Errorf("member %d: %w", len(members), err)
+		}
+		members = append(members, p[:memberLength:memberLength])
+		p = p[memberLength:]
+	}
+	return members, nil
+}
+
+// MemberSections returns an io.SectionReader over each gzip member of the
+// stream p, in order, for random access to individual members without
+// copying them. Each section reads a standalone, single-member gzip blob.
+// Members are located the same way as in SplitMembers, and a malformed
+// member returns an error wrapping ErrBlob.
+func MemberSections(p []byte) ([]*io.SectionReader, error) {
+	members, err := SplitMembers(p)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(p)
+	sections := make([]*io.SectionReader, len(members))
+	var offset int64
+	for i, member := range members {
+		sections[i] = io.NewSectionReader(r, offset, int64(len(member)))
+		offset += int64(len(member))
+	}
+	return sections, nil
+}
+
+// StreamInfo describes the gzip members of a stream, as found by Inspect.
+type StreamInfo struct {
+	Members []MemberInfo
+}
+
+// MemberInfo describes a single gzip member of a stream.
+type MemberInfo struct {
+	Offset           int        // Offset of the member's header in the stream.
+	Length           int        // Length of the whole member, header to trailer.
+	Header           BlobHeader // Parsed header. Header.Length is its size.
+	Flags            byte       // The header's FLG byte, as written.
+	CompressedLength int        // Length of the DEFLATE data.
+	CRC32            uint32     // CRC32 field of the trailer.
+	ISIZE            uint32     // ISIZE field of the trailer.
+}
+
+// Inspect walks the gzip members of the stream p, and describes each one,
+// for debugging malformed streams. Members are located the same way as in
+// CountMembers, so the DEFLATE data is walked, but not decompressed, and the
+// trailer fields are reported as written, without being checked.
+//
+// If a member is malformed, Inspect returns the members before it, along with
+// an error wrapping ErrBlob, naming the failing member's index and offset.
+func Inspect(p []byte) (StreamInfo, error) {
+	var info StreamInfo
+	for offset := 0; offset < len(p); {
+		member, err := inspectMember(p[offset:])
+		if err != nil {
+			return info, fmt.Errorf("member %d at offset %d: %w", len(info.Members), offset, err)
+		}
+		member.Offset = offset
+		info.Members = append(info.Members, member)
+		offset += member.Length
+	}
+	return info, nil
+}
+
+// inspectMember describes the gzip member at the start of p.
+func inspectMember(p []byte) (MemberInfo, error) {
+	header, err := ParseBlobHeader(p)
+	if err != nil {
+		return MemberInfo{}, err
+	}
+	scan, err := scanDeflate(p[header.Length:])
+	if err != nil {
+		return MemberInfo{}, err
+	}
+	end := header.Length + scan.length
+	if len(p) < end+8 {
+		return MemberInfo{}, fmt.Errorf("%w: truncated trailer", ErrBlob)
+	}
+	return MemberInfo{
+		Length:           end + 8,
+		Header:           header,
+		Flags:            p[3],
+		CompressedLength: scan.length,
+		CRC32:            binary.LittleEndian.Uint32(p[end : end+4]),
+		ISIZE:            binary.LittleEndian.Uint32(p[end+4 : end+8]),
+	}, nil
+}
+
+// MergeStreams merges the gzip streams a and b into a single gzip member,
+// which it writes to dst. Every member of both streams is spliced in, in
+// order, like WriteCompressed does, and their CRC32 and ISIZE fields are
+// combined into one trailer. The header is copied from the first member, as
+// with NewGzipStreamWriterFromHeader.
+//
+// Note that concatenating a and b as-is is already a valid gzip stream, with
+// the members of both, which any multistream reader (such as the stdlib's)
+// decompresses to the same data. Merging is for consumers that only read the
+// first member, or that care about the member count. It costs a walk over
+// the DEFLATE blocks of every member, but no decompression.
+//
+// Both streams are checked before anything is written, and a malformed
+// member returns an error wrapping ErrBlob. If both streams are empty,
+// nothing is written.
+func MergeStreams(dst io.Writer, a, b []byte) error {
+	membersA, err := SplitMembers(a)
+	if err != nil {
+		return fmt.Errorf("stream a: %w", err)
+	}
+	membersB, err := SplitMembers(b)
+	if err != nil {
+		return fmt.Errorf("stream b: %w", err)
+	}
+	members := slices.Concat(membersA, membersB)
+	if len(members) == 0 {
+		return nil
+	}
+
+	z, err := NewGzipStreamWriterFromHeader(dst, members[0])
+	if err != nil {
+		return err
+	}
+	for i, member := range members {
+		if _, err := z.WriteCompressed(member); err != nil {
+			return fmt.Errorf("member %d: %w", i, err)
+		}
+	}
+	return z.Close()
+}
+
+// TrimTrailingEmptyMember returns p without its last gzip member, if that
+// member is empty, and at least one member comes before it. Some encoders
+// write an empty member when closing a stream with no data, which then ends
+// up at the end of concatenated output.
+//
+// A member only counts as empty if its ISIZE and CRC32 fields are zero, and
+// its DEFLATE stream is one of the known encodings of an empty stream, so a
+// small member that merely compresses well is never removed. If p is
+// malformed, or has no trailing empty member, p is returned unchanged.
+// Otherwise, the result is a subslice of p.
+func TrimTrailingEmptyMember(p []byte) []byte {
+	start, last := 0, 0
+	for start < len(p) {
+		memberLength, err := getMemberLength(p[start:])
+		if err != nil {
+			return p
+		}
+		last = start
+		start += memberLength
+	}
+	if last == 0 || !isEmptyMember(p[last:]) {
+		return p
+	}
+	return p[:last]
+}
+
+// isEmptyMember reports whether the complete gzip member is known to hold no
+// data, going by its trailer and DEFLATE stream.
+func isEmptyMember(member []byte) bool {
+	deflate, err := getDeflateSlice(member)
+	if err != nil {
+		return false
+	}
+	trailer := member[len(member)-8:]
+	if binary.LittleEndian.Uint32(trailer[:4]) != 0 || binary.LittleEndian.Uint32(trailer[4:]) != 0 {
+		return false
+	}
+	return slices.ContainsFunc(emptyDeflateStreams, func(empty []byte) bool {
+		return bytes.Equal(deflate, empty)
+	})
+}
+
+// RepairTrailer returns a copy of the gzip stream p, with the CRC32 and ISIZE
+// fields in the trailer of its last member recomputed. This recovers a
+// stream whose last trailer got corrupted while its DEFLATE payload is still
+// intact. Unlike the rest of this package, it decompresses the member to do
+// so. The earlier members are copied as-is, without being checked.
+//
+// A malformed stream, or a last member whose payload does not decompress,
+// returns an error wrapping ErrBlob.
+func RepairTrailer(p []byte) ([]byte, error) {
+	members, err := SplitMembers(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("%w: no members", ErrBlob)
+	}
+	last := members[len(members)-1]
+	deflate, err := getDeflateSlice(last)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor := flate.NewReader(bytes.NewReader(deflate))
+	defer decompressor.Close() //nolint:errcheck
+	digest := crc32.NewIEEE()
+	size, err := io.Copy(digest, decompressor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: last member: %w", ErrBlob, err)
+	}
+
+	repaired := slices.Clone(p)
+	trailer := repaired[len(repaired)-8:]
+	binary.LittleEndian.PutUint32(trailer[:4], digest.Sum32())
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(size))
+	return repaired, nil
+}
@@ -0,0 +1,654 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestCountMembers(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note   string
+		stream []byte
+		count  int
+		err    error
+	}{
+		{
+			note:   "empty stream",
+			stream: nil,
+			count:  0,
+		},
+		{
+			note:   "single member",
+			stream: compressStdlib(t, []byte("hello, world!")),
+			count:  1,
+		},
+		{
+			note: "several members",
+			stream: slices.Concat(
+				compressStdlib(t, []byte("hello, ")),
+				compressStdlib(t, nil),
+				compressStdlib(t, bytes.Repeat([]byte("world! "), 1000)),
+			),
+			count: 3,
+		},
+		{
+			note:   "truncated final trailer",
+			stream: slices.Concat(compressStdlib(t, []byte("A")), compressStdlib(t, []byte("B"))[:20]),
+			count:  1,
+			err:    gzipstreamwriter.ErrBlob,
+		},
+		{
+			note:   "trailing garbage",
+			stream: slices.Concat(compressStdlib(t, []byte("A")), []byte("garbage")),
+			count:  1,
+			err:    gzipstreamwriter.ErrBlob,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			count, err := gzipstreamwriter.CountMembers(tc.stream)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if count != tc.count {
+				t.Fatalf("expected %d members, got %d", tc.count, count)
+			}
+		})
+	}
+}
+
+func TestSplitMembers(t *testing.T) {
+	t.Parallel()
+
+	blobs := [][]byte{
+		compressStdlib(t, []byte("hello, ")),
+		compressStdlib(t, nil),
+		compressStdlib(t, bytes.Repeat([]byte("world! "), 1000)),
+	}
+
+	t.Run("several members", func(t *testing.T) {
+		t.Parallel()
+
+		members, err := gzipstreamwriter.SplitMembers(slices.Concat(blobs...))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(blobs, members); diff != "" {
+			t.Fatalf("TestSplitMembers() mismatch (-want +got):\n%s", diff)
+		}
+
+		// Each member is a valid blob on its own.
+		for i, member := range members {
+			if _, err := gzipstreamwriter.DecompressAll(bytes.NewReader(member)); err != nil {
+				t.Fatalf("member %d: expected no error, got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("members from NextMember", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+		for i, input := range []string{"first", "second", "third"} {
+			if i > 0 {
+				if err := actGzipWriter.NextMember(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := actGzipWriter.Write([]byte(input)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		members, err := gzipstreamwriter.SplitMembers(actBuffer.Bytes())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var results []string
+		for _, member := range members {
+			result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(member))
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, string(result))
+		}
+		if diff := cmp.Diff([]string{"first", "second", "third"}, results); diff != "" {
+			t.Fatalf("TestSplitMembers() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		t.Parallel()
+
+		members, err := gzipstreamwriter.SplitMembers(nil)
+		if err != nil || len(members) != 0 {
+			t.Fatalf("expected no members and no error, got %d members, error %v", len(members), err)
+		}
+	})
+
+	t.Run("malformed member", func(t *testing.T) {
+		t.Parallel()
+
+		stream := slices.Concat(blobs[0], []byte("garbage"))
+		if _, err := gzipstreamwriter.SplitMembers(stream); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestCountMembersHeaderStrings(t *testing.T) {
+	t.Parallel()
+
+	var stream bytes.Buffer
+	for _, name := range []string{"a.json", "b.json", "c.json"} {
+		w := gzip.NewWriter(&stream)
+		w.Name = name
+		w.Comment = "comment for " + name
+		if _, err := w.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := gzipstreamwriter.CountMembers(stream.Bytes())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected %d members, got %d", 3, count)
+	}
+}
+
+func TestNextMember(t *testing.T) {
+	t.Parallel()
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&actBuffer)
+	actGzipWriter.Name = "events.json"
+	if _, err := actGzipWriter.Write([]byte("hello, ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.NextMember(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := actGzipWriter.Write(bytes.Repeat([]byte("world! "), 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.NextMember(); err != nil {
+		t.Fatalf("expected no error after close, got %v", err)
+	}
+
+	count, err := gzipstreamwriter.CountMembers(actBuffer.Bytes())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected %d members, got %d", 2, count)
+	}
+
+	// Each member must be valid on its own, including its header and trailer.
+	gzReader, err := gzip.NewReader(&actBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzReader.Multistream(false)
+	expMembers := [][]byte{[]byte("hello, "), bytes.Repeat([]byte("world! "), 100)}
+	for i, expMember := range expMembers {
+		if i > 0 {
+			if err := gzReader.Reset(&actBuffer); err != nil {
+				t.Fatal(err)
+			}
+			gzReader.Multistream(false)
+		}
+		if gzReader.Name != "events.json" {
+			t.Fatalf("member %d: expected name %q, got %q", i, "events.json", gzReader.Name)
+		}
+		result, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatalf("member %d: expected no error, got %v", i, err)
+		}
+		if !bytes.Equal(expMember, result) {
+			t.Fatalf("member %d: expected %q, got %q", i, expMember, result)
+		}
+	}
+}
+
+func TestFlushMember(t *testing.T) {
+	t.Parallel()
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(&actBuffer)
+
+	// Nothing written yet, so there is no member to emit.
+	if err := actGzipWriter.FlushMember(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if actBuffer.Len() != 0 {
+		t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+	}
+
+	var expResult []byte
+	for i, record := range []string{"first record\n", "second record\n", "third record\n"} {
+		if _, err := actGzipWriter.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.FlushMember(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expResult = append(expResult, record...)
+
+		// Everything so far must be a complete, valid stream on its own.
+		result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actBuffer.Bytes()))
+		if err != nil {
+			t.Fatalf("record %d: expected no error, got %v", i, err)
+		}
+		if !bytes.Equal(expResult, result) {
+			t.Fatalf("record %d: expected %q, got %q", i, expResult, result)
+		}
+	}
+
+	// A torn write after the last FlushMember only loses the torn member.
+	if _, err := actGzipWriter.Write([]byte("torn record\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := actGzipWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(actBuffer.Bytes()))
+	if err == nil {
+		t.Fatal("expected an error for the unfinished member")
+	}
+	if !bytes.HasPrefix(result, expResult) {
+		t.Fatalf("expected recovered prefix %q, got %q", expResult, result)
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	t.Parallel()
+
+	// A stream from this package, with a named header and two members.
+	streamA := bytes.Buffer{}
+	gzWriter := gzipstreamwriter.NewGzipStreamWriter(&streamA)
+	gzWriter.Name = "a.txt"
+	if _, err := gzWriter.Write([]byte("hello, ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.NextMember(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gzWriter.Write(randomTestBytes(10_000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	streamB := compressStdlib(t, []byte("world!"))
+
+	testcases := []struct {
+		note    string
+		a, b    []byte
+		members int
+		name    string
+	}{
+		{note: "two streams", a: streamA.Bytes(), b: streamB, members: 1, name: "a.txt"},
+		{note: "empty first stream", a: nil, b: streamB, members: 1},
+		{note: "empty second stream", a: streamA.Bytes(), b: nil, members: 1, name: "a.txt"},
+		{note: "both empty", a: nil, b: nil, members: 0},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			if err := gzipstreamwriter.MergeStreams(&actBuffer, tc.a, tc.b); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			count, err := gzipstreamwriter.CountMembers(actBuffer.Bytes())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if count != tc.members {
+				t.Fatalf("expected %d members, got %d", tc.members, count)
+			}
+			if count == 0 {
+				return
+			}
+
+			// The merged stream decompresses to the same data as the plain
+			// concatenation, even with multistream reading turned off.
+			expected, err := gzipstreamwriter.DecompressAll(bytes.NewReader(slices.Concat(tc.a, tc.b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			gzReader.Multistream(false)
+			if gzReader.Name != tc.name {
+				t.Fatalf("expected name %q, got %q", tc.name, gzReader.Name)
+			}
+			actual, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Fatalf("TestMergeStreams() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("malformed stream", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		err := gzipstreamwriter.MergeStreams(&actBuffer, streamA.Bytes(), streamB[:len(streamB)-3])
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if actBuffer.Len() != 0 {
+			t.Fatalf("expected no output, got %d bytes", actBuffer.Len())
+		}
+	})
+}
+
+func TestTrimTrailingEmptyMember(t *testing.T) {
+	t.Parallel()
+
+	readBlob := func(name string) []byte {
+		blob, err := os.ReadFile(filepath.Join("testdata", "blobs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+	stdlibDefault := readBlob("stdlib-default.gz")
+	stdlibEmpty := readBlob("stdlib-empty.gz")
+	klauspostDefault := readBlob("klauspost-default.gz")
+	klauspostEmpty := readBlob("klauspost-empty.gz")
+	smallMember := compressStdlib(t, []byte("a"))
+	// An empty final stored block, with an empty trailer.
+	storedEmpty := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255, 0x01, 0x00, 0x00, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	testcases := []struct {
+		note     string
+		input    []byte
+		expected []byte
+	}{
+		{
+			note:     "stdlib empty member",
+			input:    slices.Concat(stdlibDefault, stdlibEmpty),
+			expected: stdlibDefault,
+		},
+		{
+			note:     "klauspost empty member",
+			input:    slices.Concat(klauspostDefault, klauspostEmpty),
+			expected: klauspostDefault,
+		},
+		{
+			note:     "empty stored block",
+			input:    slices.Concat(stdlibDefault, storedEmpty),
+			expected: stdlibDefault,
+		},
+		{
+			note:     "only the last empty member",
+			input:    slices.Concat(stdlibDefault, klauspostEmpty, stdlibEmpty),
+			expected: slices.Concat(stdlibDefault, klauspostEmpty),
+		},
+		{
+			note:     "single empty member",
+			input:    stdlibEmpty,
+			expected: stdlibEmpty,
+		},
+		{
+			note:     "leading empty member",
+			input:    slices.Concat(klauspostEmpty, stdlibDefault),
+			expected: slices.Concat(klauspostEmpty, stdlibDefault),
+		},
+		{
+			note:     "small last member",
+			input:    slices.Concat(stdlibDefault, smallMember),
+			expected: slices.Concat(stdlibDefault, smallMember),
+		},
+		{
+			note:     "trailing garbage",
+			input:    slices.Concat(stdlibDefault, stdlibEmpty, []byte("garbage")),
+			expected: slices.Concat(stdlibDefault, stdlibEmpty, []byte("garbage")),
+		},
+		{
+			note:     "empty input",
+			input:    nil,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actual := gzipstreamwriter.TrimTrailingEmptyMember(tc.input)
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Fatalf("TestTrimTrailingEmptyMember() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMemberSections(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{[]byte("hello, "), nil, bytes.Repeat([]byte("world! "), 1000)}
+	var blobs [][]byte
+	for _, input := range inputs {
+		blobs = append(blobs, compressStdlib(t, input))
+	}
+	stream := slices.Concat(blobs...)
+
+	sections, err := gzipstreamwriter.MemberSections(stream)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(sections) != len(blobs) {
+		t.Fatalf("expected %d sections, got %d", len(blobs), len(sections))
+	}
+	// Read the sections out of order, to check they are independent.
+	for _, i := range []int{2, 0, 1} {
+		member, err := io.ReadAll(sections[i])
+		if err != nil {
+			t.Fatalf("section %d: expected no error, got %v", i, err)
+		}
+		if diff := cmp.Diff(blobs[i], member); diff != "" {
+			t.Fatalf("TestMemberSections() mismatch (-want +got):\n%s", diff)
+		}
+		gzReader, err := gzip.NewReader(io.NewSectionReader(sections[i], 0, sections[i].Size()))
+		if err != nil {
+			t.Fatalf("section %d: expected no error, got %v", i, err)
+		}
+		result, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatalf("section %d: expected no error, got %v", i, err)
+		}
+		if !bytes.Equal(inputs[i], result) {
+			t.Fatalf("section %d: expected %d bytes of output, got %d bytes", i, len(inputs[i]), len(result))
+		}
+	}
+
+	t.Run("malformed member", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.MemberSections(slices.Concat(blobs[0], []byte("garbage"))); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestRepairTrailer(t *testing.T) {
+	t.Parallel()
+
+	first := compressStdlib(t, []byte("hello, "))
+	input := randomTestBytes(50_000)
+	last := compressStdlib(t, input)
+	stream := slices.Concat(first, last)
+
+	testcases := []struct {
+		note    string
+		corrupt func(p []byte)
+	}{
+		{note: "intact trailer", corrupt: func([]byte) {}},
+		{note: "corrupt CRC32", corrupt: func(p []byte) { p[len(p)-6] ^= 0xff }},
+		{note: "corrupt ISIZE", corrupt: func(p []byte) { p[len(p)-1] = 0x7f }},
+		{note: "zeroed trailer", corrupt: func(p []byte) { clear(p[len(p)-8:]) }},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			corrupted := bytes.Clone(stream)
+			tc.corrupt(corrupted)
+			actual, err := gzipstreamwriter.RepairTrailer(corrupted)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff(stream, actual); diff != "" {
+				t.Fatalf("TestRepairTrailer() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("undecodable payload", func(t *testing.T) {
+		t.Parallel()
+
+		// A fixed Huffman block that copies from before the start of the
+		// data. The member scan does not track the output, but decoding fails.
+		corrupted := slices.Concat(first, []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255, 0x03, 0x02, 0x00, 0, 0, 0, 0, 0, 0, 0, 0})
+		if _, err := gzipstreamwriter.SplitMembers(corrupted); err != nil {
+			t.Fatalf("expected the member scan to pass, got %v", err)
+		}
+		if _, err := gzipstreamwriter.RepairTrailer(corrupted); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.RepairTrailer(nil); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+	})
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	first := []byte("hello, ")
+	second := bytes.Repeat([]byte("world! "), 1000)
+	var named bytes.Buffer
+	gzWriter := gzip.NewWriter(&named)
+	gzWriter.Name = "first.txt"
+	gzWriter.Comment = "café"
+	if _, err := gzWriter.Write(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	plain := compressStdlib(t, second)
+	stream := slices.Concat(named.Bytes(), plain)
+
+	expected := gzipstreamwriter.StreamInfo{
+		Members: []gzipstreamwriter.MemberInfo{
+			{
+				Offset: 0,
+				Length: named.Len(),
+				Header: gzipstreamwriter.BlobHeader{
+					Header: gzip.Header{Name: "first.txt", Comment: "café", OS: 255},
+					Length: 10 + len("first.txt") + 1 + len("caf\xe9") + 1,
+				},
+				Flags:            0x18,
+				CompressedLength: named.Len() - 10 - len("first.txt") - 1 - len("caf\xe9") - 1 - 8,
+				CRC32:            crc32.ChecksumIEEE(first),
+				ISIZE:            uint32(len(first)),
+			},
+			{
+				Offset: named.Len(),
+				Length: len(plain),
+				Header: gzipstreamwriter.BlobHeader{
+					Header: gzip.Header{OS: 255},
+					Length: 10,
+				},
+				CompressedLength: len(plain) - 10 - 8,
+				CRC32:            crc32.ChecksumIEEE(second),
+				ISIZE:            uint32(len(second)),
+			},
+		},
+	}
+
+	t.Run("valid stream", func(t *testing.T) {
+		t.Parallel()
+
+		info, err := gzipstreamwriter.Inspect(stream)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(expected, info); diff != "" {
+			t.Fatalf("TestInspect() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		t.Parallel()
+
+		info, err := gzipstreamwriter.Inspect(nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(info.Members) != 0 {
+			t.Fatalf("expected no members, got %d", len(info.Members))
+		}
+	})
+
+	t.Run("malformed member", func(t *testing.T) {
+		t.Parallel()
+
+		info, err := gzipstreamwriter.Inspect(slices.Concat(stream, []byte("garbage")))
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		if want := fmt.Sprintf("member 2 at offset %d", len(stream)); !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error to mention %q, got %v", want, err)
+		}
+		// The members before it are still reported.
+		if diff := cmp.Diff(expected, info); diff != "" {
+			t.Fatalf("TestInspect() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
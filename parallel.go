@@ -0,0 +1,226 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// parallelChunkSize is the amount of uncompressed data that a
+// ParallelGzipStreamWriter compresses as one independent piece.
+const parallelChunkSize = 1 << 20
+
+// ParallelGzipStreamWriter is a gzip writer that compresses its input on
+// multiple goroutines. Input is split into fixed-size chunks, each chunk is
+// compressed independently, and the compressed chunks are spliced into a
+// single gzip stream in order, with the CRC32 of each chunk combined into the
+// stream's trailer.
+//
+// Since each chunk is compressed without the history of the chunks before it,
+// the output is slightly larger than a sequential writer's, in exchange for
+// higher throughput on multi-core machines. The output is a single standard
+// gzip member, which any gzip reader can decompress.
+//
+// A ParallelGzipStreamWriter must not be used from multiple goroutines at once.
+type ParallelGzipStreamWriter struct {
+	z           *GzipStreamWriter
+	level       int
+	workers     int
+	compressors *sync.Pool
+	pending     *parallelChunk   // Chunk currently being filled by Write.
+	inflight    []*parallelChunk // Chunks being compressed, oldest first.
+	free        []*parallelChunk // Written chunks, kept for reuse.
+	err         error
+	closed      bool // Set once Close has written the trailer.
+}
+
+// parallelChunk is a chunk of input, and the result of compressing it.
+type parallelChunk struct {
+	input    []byte
+	output   bytes.Buffer
+	checksum uint32
+	done     chan struct{} // Closed once output and checksum are ready.
+}
+
+// WithFlatePool makes a ParallelGzipStreamWriter take its compressors from
+// pool, and return them there, instead of from a pool of its own. Each writer
+// only has as many compressors in use as it has workers, so sharing one pool
+// between writers that come and go keeps bursty workloads from allocating a
+// fresh set of compressors for every writer.
+//
+// The pool must only hold *flate.Writer values for the writer's compression
+// level. If its New function is nil, or returns something else, a new
+// compressor is created instead. A GzipStreamWriter ignores this option.
+func WithFlatePool(pool *sync.Pool) Option {
+	return func(o *writerOptions) {
+		o.flatePool = pool
+	}
+}
+
+// NewParallelGzipStreamWriter creates a new ParallelGzipStreamWriter that
+// compresses at the specified level, using up to workers goroutines.
+// If workers is less than 1, runtime.GOMAXPROCS(0) is used. The options are
+// applied to the GzipStreamWriter that assembles the output.
+func NewParallelGzipStreamWriter(w io.Writer, level, workers int, opts ...Option) (*ParallelGzipStreamWriter, error) {
+	z, err := NewGzipStreamWriterLevel(w, level, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &ParallelGzipStreamWriter{
+		z:           z,
+		level:       level,
+		workers:     workers,
+		compressors: z.options.flatePool,
+		inflight:    make([]*parallelChunk, 0, workers),
+	}
+	if p.compressors == nil {
+		p.compressors = &sync.Pool{
+			New: func() any {
+				// NewGzipStreamWriterLevel accepted the level, so flate will too.
+				compressor, _ := flate.NewWriter(nil, level)
+				return compressor
+			},
+		}
+	}
+	return p, nil
+}
+
+// Write buffers p, and starts compressing each chunk of input as it fills up.
+// Once the maximum number of chunks is in flight, Write blocks until the
+// oldest one is compressed and written out.
+func (p *ParallelGzipStreamWriter) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	if p.closed {
+		return 0, ErrClosed
+	}
+
+	written := 0
+	for len(b) > 0 {
+		if p.pending == nil {
+			p.pending = p.getChunk()
+		}
+		n := min(len(b), parallelChunkSize-len(p.pending.input))
+		p.pending.input = append(p.pending.input, b[:n]...)
+		b = b[n:]
+		written += n
+		if len(p.pending.input) == parallelChunkSize {
+			if err := p.dispatch(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses any buffered input, and writes out all chunks in flight.
+func (p *ParallelGzipStreamWriter) Flush() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed {
+		return ErrClosed
+	}
+	if p.pending != nil && len(p.pending.input) > 0 {
+		if err := p.dispatch(); err != nil {
+			return err
+		}
+	}
+	for len(p.inflight) > 0 {
+		if err := p.writeOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes any buffered input, and writes the gzip trailer.
+// It does not close the underlying writer. After Close, Write and Flush
+// return ErrClosed, and further calls to Close do nothing.
+func (p *ParallelGzipStreamWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	if p.err = p.z.Close(); p.err != nil {
+		return p.err
+	}
+	p.closed = true
+	return nil
+}
+
+func (p *ParallelGzipStreamWriter) getChunk() *parallelChunk {
+	if n := len(p.free); n > 0 {
+		c := p.free[n-1]
+		p.free = p.free[:n-1]
+		return c
+	}
+	return &parallelChunk{input: make([]byte, 0, parallelChunkSize)}
+}
+
+// dispatch starts compressing the pending chunk on a new goroutine.
+func (p *ParallelGzipStreamWriter) dispatch() error {
+	// Keep at most p.workers chunks in flight.
+	if len(p.inflight) == p.workers {
+		if err := p.writeOldest(); err != nil {
+			return err
+		}
+	}
+	c := p.pending
+	p.pending = nil
+	c.done = make(chan struct{})
+	p.inflight = append(p.inflight, c)
+	go p.compress(c)
+	return nil
+}
+
+// compress compresses a chunk into a byte-aligned, non-final piece of DEFLATE
+// data, which can be spliced into the output stream.
+func (p *ParallelGzipStreamWriter) compress(c *parallelChunk) {
+	defer close(c.done)
+
+	compressor, ok := p.compressors.Get().(*flate.Writer)
+	if !ok {
+		compressor, _ = flate.NewWriter(nil, p.level)
+	}
+	defer p.compressors.Put(compressor)
+	compressor.Reset(&c.output)
+	// Writes to a bytes.Buffer cannot fail.
+	_, _ = compressor.Write(c.input)
+	// Flush ends the piece with a sync marker, leaving it byte-aligned, and
+	// without the final block bit set.
+	_ = compressor.Flush()
+	c.checksum = crc32.ChecksumIEEE(c.input)
+}
+
+// writeOldest waits for the oldest chunk in flight, and writes it out.
+func (p *ParallelGzipStreamWriter) writeOldest() error {
+	c := p.inflight[0]
+	p.inflight = append(p.inflight[:0], p.inflight[1:]...)
+	<-c.done
+
+	if _, err := p.z.writeDeflate(c.checksum, uint32(len(c.input)), c.output.Bytes()); err != nil {
+		p.err = fmt.Errorf("gzip: failed to write compressed chunk: %w", err)
+		return p.err
+	}
+	c.input = c.input[:0]
+	c.output.Reset()
+	p.free = append(p.free, c)
+	return nil
+}
+
+var _ io.WriteCloser = (*ParallelGzipStreamWriter)(nil)
@@ -0,0 +1,286 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestParallelGzipStreamWriter(t *testing.T) {
+	t.Parallel()
+
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 80000) // ~3.6 MB.
+
+	testcases := []struct {
+		note    string
+		level   int
+		workers int
+		input   []byte
+	}{
+		{
+			note:    "empty input",
+			level:   gzipstreamwriter.DefaultCompression,
+			workers: 4,
+			input:   nil,
+		},
+		{
+			note:    "less than one chunk",
+			level:   gzipstreamwriter.DefaultCompression,
+			workers: 4,
+			input:   []byte("hello, world!"),
+		},
+		{
+			note:    "exactly one chunk",
+			level:   gzipstreamwriter.BestSpeed,
+			workers: 4,
+			input:   text[:1<<20],
+		},
+		{
+			note:    "several chunks, one worker",
+			level:   gzipstreamwriter.BestSpeed,
+			workers: 1,
+			input:   text,
+		},
+		{
+			note:    "several chunks, many workers",
+			level:   gzipstreamwriter.BestCompression,
+			workers: 3,
+			input:   text,
+		},
+		{
+			note:    "default worker count",
+			level:   gzipstreamwriter.HuffmanOnly,
+			workers: 0,
+			input:   text,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewParallelGzipStreamWriter(&actBuffer, tc.level, tc.workers)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// Write in odd-sized pieces, to exercise chunk boundaries.
+			for input := tc.input; len(input) > 0; {
+				n := min(len(input), 300000)
+				if _, err := actGzipWriter.Write(input[:n]); err != nil {
+					t.Fatal(err)
+				}
+				input = input[n:]
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The output must be a single member, which the stdlib reader
+			// checks the CRC32 and size of.
+			gzReader, err := gzip.NewReader(&actBuffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gzReader.Multistream(false)
+			result, err := io.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(tc.input, result) {
+				t.Fatalf("expected %d bytes of decompressed data, got %d bytes", len(tc.input), len(result))
+			}
+			if actBuffer.Len() != 0 {
+				t.Fatalf("expected a single member, got %d trailing bytes", actBuffer.Len())
+			}
+		})
+	}
+
+	t.Run("flush mid-stream", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewParallelGzipStreamWriter(&actBuffer, gzipstreamwriter.DefaultCompression, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := actGzipWriter.Write([]byte("hello, ")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		flushedLen := actBuffer.Len()
+		if flushedLen == 0 {
+			t.Fatalf("expected output after Flush, got none")
+		}
+		if _, err := actGzipWriter.Write([]byte("world!")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if string(result) != "hello, world!" {
+			t.Fatalf("expected %q, got %q", "hello, world!", result)
+		}
+	})
+
+	t.Run("write errors are sticky", func(t *testing.T) {
+		t.Parallel()
+
+		actGzipWriter, err := gzipstreamwriter.NewParallelGzipStreamWriter(&failingWriter{okWrites: 1}, gzipstreamwriter.BestSpeed, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := actGzipWriter.Write(text); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if _, err := actGzipWriter.Write([]byte("more")); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+		if err := actGzipWriter.Close(); !errors.Is(err, errTestWrite) {
+			t.Fatalf("expected error %v, got %v", errTestWrite, err)
+		}
+	})
+
+	t.Run("use after close", func(t *testing.T) {
+		t.Parallel()
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter, err := gzipstreamwriter.NewParallelGzipStreamWriter(&actBuffer, gzipstreamwriter.BestSpeed, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := actGzipWriter.Write([]byte("hello, world!\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		size := actBuffer.Len()
+
+		if _, err := actGzipWriter.Write([]byte("more")); !errors.Is(err, gzipstreamwriter.ErrClosed) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrClosed, err)
+		}
+		if err := actGzipWriter.Flush(); !errors.Is(err, gzipstreamwriter.ErrClosed) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrClosed, err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if actBuffer.Len() != size {
+			t.Fatalf("expected %d bytes of output, got %d bytes", size, actBuffer.Len())
+		}
+		result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal([]byte("hello, world!\n"), result) {
+			t.Fatalf("expected %q, got %q", "hello, world!\n", result)
+		}
+	})
+
+	t.Run("shared flate pool", func(t *testing.T) {
+		t.Parallel()
+
+		var created atomic.Int64
+		pool := &sync.Pool{
+			New: func() any {
+				created.Add(1)
+				compressor, _ := flate.NewWriter(nil, gzipstreamwriter.BestSpeed)
+				return compressor
+			},
+		}
+		for range 4 {
+			actBuffer := bytes.Buffer{}
+			actGzipWriter, err := gzipstreamwriter.NewParallelGzipStreamWriter(&actBuffer, gzipstreamwriter.BestSpeed, 2, gzipstreamwriter.WithFlatePool(pool))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := actGzipWriter.Write(text); err != nil {
+				t.Fatal(err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			result, err := gzipstreamwriter.DecompressAll(&actBuffer)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !bytes.Equal(text, result) {
+				t.Fatalf("expected %d bytes of decompressed data, got %d bytes", len(text), len(result))
+			}
+		}
+		if created.Load() == 0 {
+			t.Fatal("expected compressors to come from the shared pool")
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.NewParallelGzipStreamWriter(io.Discard, 11, 2); !errors.Is(err, gzipstreamwriter.ErrInvalidCompressionLevel) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrInvalidCompressionLevel, err)
+		}
+	})
+}
+
+func BenchmarkParallelGzipStreamWriter(b *testing.B) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 200000)
+	b.SetBytes(int64(len(input)))
+
+	for b.Loop() {
+		z, err := gzipstreamwriter.NewParallelGzipStreamWriter(io.Discard, gzipstreamwriter.DefaultCompression, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := z.Write(input); err != nil {
+			b.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelGzipStreamWriterFlatePool(b *testing.B) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 200000)
+	b.SetBytes(int64(len(input)))
+
+	// Every writer draws from the same pool, so once it has warmed up, no
+	// more compressors are created. The compressors/op metric shows this.
+	var created atomic.Int64
+	pool := &sync.Pool{
+		New: func() any {
+			created.Add(1)
+			compressor, _ := flate.NewWriter(nil, gzipstreamwriter.DefaultCompression)
+			return compressor
+		},
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		z, err := gzipstreamwriter.NewParallelGzipStreamWriter(io.Discard, gzipstreamwriter.DefaultCompression, 0, gzipstreamwriter.WithFlatePool(pool))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := z.Write(input); err != nil {
+			b.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(created.Load())/float64(b.N), "compressors/op")
+}
@@ -0,0 +1,51 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+var writerPool = sync.Pool{
+	New: func() any {
+		return NewGzipStreamWriter(nil)
+	},
+}
+
+// GetWriter returns a GzipStreamWriter from a package-level pool, reset to
+// write to w at the default compression level, with no options set.
+// Return the writer to the pool with PutWriter once done with it.
+func GetWriter(w io.Writer) *GzipStreamWriter {
+	z, ok := writerPool.Get().(*GzipStreamWriter)
+	if !ok {
+		return NewGzipStreamWriter(w)
+	}
+	z.Reset(w)
+	return z
+}
+
+// PutWriter returns a GzipStreamWriter to the package-level pool.
+// The writer's internal flate.Writer is retained, so that later calls to
+// GetWriter can reuse it without reallocating.
+//
+// Callers must not use z after calling PutWriter, as it may be handed out to
+// another caller of GetWriter at any time.
+// Writers with a non-default compression level, a preset dictionary, an
+// output hash, a non-IEEE checksum table, or buffered output are not pooled,
+// and are left for the garbage collector instead.
+func PutWriter(z *GzipStreamWriter) {
+	if z == nil || z.level != DefaultCompression || z.dict != nil || z.hash != nil || z.buffered != nil {
+		return
+	}
+	if z.crcTable != nil && z.crcTable != crc32.IEEETable {
+		return
+	}
+	z.options = writerOptions{}
+	// Drop the reference to the old destination, so it can be collected.
+	z.Reset(nil)
+	writerPool.Put(z)
+}
@@ -0,0 +1,124 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestWriterPool(t *testing.T) {
+	t.Parallel()
+
+	inputs := [][]byte{
+		nil,
+		[]byte("A"),
+		bytes.Repeat([]byte("A"), 1000),
+		[]byte("hello, world!"),
+	}
+
+	for _, input := range inputs {
+		expBuffer := bytes.Buffer{}
+		expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+		if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+
+		actBuffer := bytes.Buffer{}
+		actGzipWriter := gzipstreamwriter.GetWriter(&actBuffer)
+		actGzipWriter.Name = "should not leak into the next writer"
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+		gzipstreamwriter.PutWriter(actGzipWriter)
+
+		// A second writer from the pool should behave like a fresh one.
+		actBuffer.Reset()
+		actGzipWriter = gzipstreamwriter.GetWriter(&actBuffer)
+		if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+			t.Fatal(err)
+		}
+		gzipstreamwriter.PutWriter(actGzipWriter)
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+			t.Fatalf("TestWriterPool() mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestPutWriterNonDefault(t *testing.T) {
+	t.Parallel()
+
+	// Writers with non-default settings must not be handed out by GetWriter.
+	for range 10 {
+		z, err := gzipstreamwriter.NewGzipStreamWriterLevel(nil, gzipstreamwriter.BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzipstreamwriter.PutWriter(z)
+		gzipstreamwriter.PutWriter(nil)
+
+		z, err = gzipstreamwriter.NewStreamWriterChecksum(nil, gzipstreamwriter.DefaultCompression, crc32.MakeTable(crc32.Castagnoli))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzipstreamwriter.PutWriter(z)
+		gzipstreamwriter.PutWriter(gzipstreamwriter.NewGzipStreamWriterBuffered(nil))
+	}
+
+	input := bytes.Repeat([]byte("ABCDEFGH"), 1000)
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	if _, err := writeToBuffer(t, expGzipWriter, input); err != nil {
+		t.Fatal(err)
+	}
+
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.GetWriter(&actBuffer)
+	if _, err := actGzipWriter.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	// An unbuffered writer has passed the header on by now.
+	if actBuffer.Len() == 0 {
+		t.Fatalf("expected the header to be written, got no output")
+	}
+	if err := actGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expBuffer.Bytes(), actBuffer.Bytes()); diff != "" {
+		t.Fatalf("TestPutWriterNonDefault() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPutWriterAutoLevel(t *testing.T) {
+	t.Parallel()
+
+	// A pooled writer that WithAutoLevel left storing members must compress
+	// again once GetWriter hands it out.
+	for range 10 {
+		z := gzipstreamwriter.NewGzipStreamWriter(io.Discard, gzipstreamwriter.WithAutoLevel(100))
+		if _, err := z.Write([]byte("hello, world!")); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		gzipstreamwriter.PutWriter(z)
+	}
+
+	input := bytes.Repeat([]byte("ABCDEFGH"), 10000)
+	actBuffer := bytes.Buffer{}
+	actGzipWriter := gzipstreamwriter.GetWriter(&actBuffer)
+	if _, err := writeToBuffer(t, actGzipWriter, input); err != nil {
+		t.Fatal(err)
+	}
+	if actBuffer.Len() > len(input)/10 {
+		t.Fatalf("expected compressed output, got %d bytes for %d bytes of input", actBuffer.Len(), len(input))
+	}
+	if actBuffer.Bytes()[8] != 0 {
+		t.Fatalf("expected XFL 0, got %d", actBuffer.Bytes()[8])
+	}
+}
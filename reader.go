@@ -0,0 +1,156 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// DecompressAll reads a complete, possibly multi-member gzip stream from r,
+// and returns the concatenated decompressed bytes of every member.
+// Each member's CRC32 and ISIZE trailer fields are checked against the
+// decompressed content. A corrupt or truncated member results in an error
+// wrapping [ErrBlob].
+func DecompressAll(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	gzReader, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBlob, err)
+	}
+	defer gzReader.Close() //nolint:errcheck
+	// The stdlib reader is multistream by default, which transparently
+	// handles member boundaries for us, and validates each member's trailer.
+	gzReader.Multistream(true)
+
+	out, err := io.ReadAll(gzReader)
+	if err != nil {
+		return out, fmt.Errorf("%w: %w", ErrBlob, err)
+	}
+	return out, nil
+}
+
+// verifyingReader decompresses a multi-member gzip stream, checking each
+// member's trailer as it goes. See NewVerifyingReader.
+type verifyingReader struct {
+	br           *bufio.Reader
+	decompressor io.ReadCloser
+	strict       bool // Rejects headers with reserved flag bits.
+	inMember     bool
+	member       int    // Index of the current member.
+	digest       uint32 // CRC32 of the current member's content so far.
+	size         uint32 // Length of the current member's content so far, mod 2^32.
+	err          error  // Terminal error, if any.
+}
+
+// NewVerifyingReader returns a reader that decompresses the multi-member gzip
+// stream read from r, and checks the CRC32 and ISIZE of each member against
+// its trailer as the member's content is read through it.
+//
+// A mismatch is reported by the Read call that reaches the end of the bad
+// member, as an error wrapping ErrBlob that names the member's index. All of
+// the member's content has been returned by then. The mismatch is not
+// terminal: callers that want to skip past a bad member can keep calling
+// Read, which continues with the next member. Any other error (such as a
+// malformed header or DEFLATE stream) is terminal, since the start of the
+// next member cannot be found.
+//
+// An empty stream contains zero members, and reads as io.EOF.
+//
+// The only option the reader uses is StrictHeaders, which makes a member
+// whose header has reserved flag bits set a terminal error. Other options
+// are ignored.
+func NewVerifyingReader(r io.Reader, opts ...Option) io.Reader {
+	var options writerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &verifyingReader{br: bufio.NewReader(r), strict: options.strictHeaders}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	for v.err == nil {
+		if !v.inMember {
+			if err := v.startMember(); err != nil {
+				v.err = err
+				break
+			}
+		}
+
+		n, err := v.decompressor.Read(p)
+		v.digest = crc32.Update(v.digest, crc32.IEEETable, p[:n])
+		v.size += uint32(n)
+		switch {
+		case errors.Is(err, io.EOF):
+			v.inMember = false
+			if err := v.finishMember(); err != nil {
+				return n, err
+			}
+		case err != nil:
+			v.err = fmt.Errorf("%w: member %d: %w", ErrBlob, v.member, err)
+			return n, v.err
+		}
+		if n > 0 || len(p) == 0 {
+			return n, nil
+		}
+	}
+	return 0, v.err
+}
+
+// startMember reads the next member's header, and sets up its decompressor.
+// At the end of the stream, it returns io.EOF.
+func (v *verifyingReader) startMember() error {
+	if _, err := v.br.Peek(1); errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	header, err := readHeader(v.br)
+	if err != nil {
+		return fmt.Errorf("member %d: %w", v.member, err)
+	}
+	if v.strict {
+		if err := checkReservedFlags(header); err != nil {
+			return fmt.Errorf("member %d: %w", v.member, err)
+		}
+	}
+	// Reading through the bufio.Reader (an io.ByteReader) keeps the
+	// decompressor from reading past the end of the DEFLATE stream.
+	if resetter, ok := v.decompressor.(flate.Resetter); ok {
+		if err := resetter.Reset(v.br, nil); err != nil {
+			return fmt.Errorf("gzip: failed to reset decompressor: %w", err)
+		}
+	} else {
+		v.decompressor = flate.NewReader(v.br)
+	}
+	v.inMember = true
+	v.digest = 0
+	v.size = 0
+	return nil
+}
+
+// finishMember reads the current member's trailer, and checks it against the
+// content read. Errors for a bad trailer leave the reader usable.
+func (v *verifyingReader) finishMember() error {
+	member := v.member
+	v.member++
+
+	var trailer [8]byte
+	if _, err := io.ReadFull(v.br, trailer[:]); err != nil {
+		v.err = fmt.Errorf("%w: member %d: truncated trailer: %w", ErrBlob, member, err)
+		return v.err
+	}
+	if checksum := binary.LittleEndian.Uint32(trailer[:4]); checksum != v.digest {
+		return fmt.Errorf("%w: member %d: CRC32 mismatch: trailer has %#08x, content has %#08x", ErrBlob, member, checksum, v.digest)
+	}
+	if size := binary.LittleEndian.Uint32(trailer[4:]); size != v.size {
+		return fmt.Errorf("%w: member %d: ISIZE mismatch: trailer has %d, content has %d", ErrBlob, member, size, v.size)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestDecompressAll(t *testing.T) {
+	t.Parallel()
+
+	t.Run("multi-member stream", func(t *testing.T) {
+		t.Parallel()
+
+		stream := slices.Concat(
+			compressStdlib(t, []byte("hello, ")),
+			compressStdlib(t, []byte("")),
+			compressStdlib(t, []byte("world!")),
+		)
+
+		result, err := gzipstreamwriter.DecompressAll(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff([]byte("hello, world!"), result); diff != "" {
+			t.Fatalf("TestDecompressAll() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("corrupt member checksum", func(t *testing.T) {
+		t.Parallel()
+
+		second := compressStdlib(t, []byte("world!"))
+		second[len(second)-8] ^= 0xff // Flip bits in the trailer's CRC32.
+		stream := slices.Concat(compressStdlib(t, []byte("hello, ")), second)
+
+		if _, err := gzipstreamwriter.DecompressAll(bytes.NewReader(stream)); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+
+	t.Run("reserved flag bits", func(t *testing.T) {
+		t.Parallel()
+
+		second := compressStdlib(t, []byte("world"))
+		second[3] |= 0x20
+		stream := slices.Concat(compressStdlib(t, []byte("hello, ")), second)
+
+		// Ignored by default.
+		result, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff([]byte("hello, world"), result); diff != "" {
+			t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+		}
+
+		reader := gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream), gzipstreamwriter.StrictHeaders(true))
+		result, err = io.ReadAll(reader)
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) || !strings.Contains(err.Error(), "member 1") {
+			t.Fatalf("expected error %v for member 1, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if diff := cmp.Diff([]byte("hello, "), result); diff != "" {
+			t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not a gzip stream", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := gzipstreamwriter.DecompressAll(bytes.NewReader([]byte("not gzip data"))); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+func TestNewVerifyingReader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("multi-member stream", func(t *testing.T) {
+		t.Parallel()
+
+		input := randomTestBytes(100_000)
+		stream := slices.Concat(
+			compressStdlib(t, input[:50_000]),
+			compressStdlib(t, []byte("")),
+			compressStdlib(t, input[50_000:]),
+		)
+
+		result, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(input, result); diff != "" {
+			t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		t.Parallel()
+
+		result, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(nil)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(result) != 0 {
+			t.Fatalf("expected no output, got %d bytes", len(result))
+		}
+	})
+
+	testCases := []struct {
+		name    string
+		offset  int // Offset of the corrupted byte, from the end of the member.
+		message string
+	}{
+		{name: "corrupt CRC32", offset: 8, message: "member 1: CRC32 mismatch"},
+		{name: "corrupt ISIZE", offset: 4, message: "member 1: ISIZE mismatch"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			second := compressStdlib(t, []byte("world"))
+			second[len(second)-tc.offset] ^= 0xff
+			stream := slices.Concat(
+				compressStdlib(t, []byte("hello, ")),
+				second,
+				compressStdlib(t, []byte("!")),
+			)
+
+			reader := gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream))
+			result, err := io.ReadAll(reader)
+			if !errors.Is(err, gzipstreamwriter.ErrBlob) {
+				t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+			}
+			if !strings.Contains(err.Error(), tc.message) {
+				t.Fatalf("expected error containing %q, got %v", tc.message, err)
+			}
+			if diff := cmp.Diff([]byte("hello, world"), result); diff != "" {
+				t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+			}
+
+			// Reading on continues past the bad member.
+			rest, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if diff := cmp.Diff([]byte("!"), rest); diff != "" {
+				t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("truncated trailer", func(t *testing.T) {
+		t.Parallel()
+
+		stream := compressStdlib(t, []byte("hello, world!"))
+		reader := gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream[:len(stream)-3]))
+		if _, err := io.ReadAll(reader); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		// The error is terminal.
+		if _, err := reader.Read(make([]byte, 1)); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+
+	t.Run("reserved flag bits", func(t *testing.T) {
+		t.Parallel()
+
+		second := compressStdlib(t, []byte("world"))
+		second[3] |= 0x20
+		stream := slices.Concat(compressStdlib(t, []byte("hello, ")), second)
+
+		// Ignored by default.
+		result, err := io.ReadAll(gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff([]byte("hello, world"), result); diff != "" {
+			t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+		}
+
+		reader := gzipstreamwriter.NewVerifyingReader(bytes.NewReader(stream), gzipstreamwriter.StrictHeaders(true))
+		result, err = io.ReadAll(reader)
+		if !errors.Is(err, gzipstreamwriter.ErrBlob) || !strings.Contains(err.Error(), "member 1") {
+			t.Fatalf("expected error %v for member 1, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+		if diff := cmp.Diff([]byte("hello, "), result); diff != "" {
+			t.Fatalf("TestNewVerifyingReader() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not a gzip stream", func(t *testing.T) {
+		t.Parallel()
+
+		reader := gzipstreamwriter.NewVerifyingReader(bytes.NewReader([]byte("not gzip data")))
+		if _, err := io.ReadAll(reader); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected error %v, got %v", gzipstreamwriter.ErrBlob, err)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Helper functions
+// ---------------------------------------------------------------------------
+
+// compressStdlib compresses data into a standalone gzip blob with the stdlib writer.
+func compressStdlib(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
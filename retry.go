@@ -0,0 +1,75 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"io"
+	"time"
+)
+
+// WriteRetryPolicy controls how writes to the underlying writer are retried
+// when they fail. See WithWriteRetry.
+type WriteRetryPolicy struct {
+	// MaxAttempts is the total number of attempts for each write, including
+	// the first one. Values below 1 mean a single attempt (no retries).
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the given retry, where the
+	// first retry is attempt 1. If nil, retries happen immediately.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable reports whether a write error is worth retrying. If nil,
+	// every error is retried.
+	Retryable func(err error) bool
+}
+
+// WithWriteRetry installs a retry policy for every write to the underlying
+// writer, including the header, compressed data, blobs from WriteCompressed,
+// and the trailer. This is for flaky destinations, such as network writers
+// that sometimes fail partway through a write.
+//
+// After a failed write, only the bytes that were not yet accepted are
+// written again. Once the attempts run out, or an error is not retryable,
+// the error becomes the writer's sticky error, like any other write error.
+func WithWriteRetry(policy WriteRetryPolicy) Option {
+	return func(o *writerOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// retryWriter applies a WriteRetryPolicy to writes to w.
+type retryWriter struct {
+	w      io.Writer
+	policy *WriteRetryPolicy
+}
+
+func (rw retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	for attempt := 1; ; attempt++ {
+		n, err := rw.w.Write(p[written:])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if attempt >= rw.policy.MaxAttempts || (rw.policy.Retryable != nil && !rw.policy.Retryable(err)) {
+			return written, err //nolint:wrapcheck
+		}
+		if rw.policy.Backoff != nil {
+			time.Sleep(rw.policy.Backoff(attempt))
+		}
+	}
+}
+
+// destination wraps w with the writer's retry policy, if it has one, and
+// with its output hash, if it has one.
+func (z *GzipStreamWriter) destination(w io.Writer) io.Writer {
+	if z.options.retryPolicy != nil {
+		w = retryWriter{w: w, policy: z.options.retryPolicy}
+	}
+	if z.hash != nil {
+		w = teeWriter{w: w, h: z.hash}
+	}
+	return w
+}
@@ -0,0 +1,137 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+var errTestRetryable = errors.New("test: retryable write error")
+
+func TestWithWriteRetry(t *testing.T) {
+	t.Parallel()
+
+	input := bytes.Repeat([]byte("hello, world! "), 1000)
+	blob := compressStdlib(t, input)
+
+	// The expected output is the same stream, written to a reliable writer.
+	expBuffer := bytes.Buffer{}
+	expGzipWriter := gzipstreamwriter.NewGzipStreamWriter(&expBuffer)
+	if _, err := expGzipWriter.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := expGzipWriter.WriteCompressed(blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := expGzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("retries recover from failures", func(t *testing.T) {
+		t.Parallel()
+
+		var backoffs []int
+		policy := gzipstreamwriter.WriteRetryPolicy{
+			MaxAttempts: 3,
+			Backoff: func(attempt int) time.Duration {
+				backoffs = append(backoffs, attempt)
+				return 0
+			},
+		}
+		actWriter := &flakyWriter{failures: 2}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(actWriter, gzipstreamwriter.WithWriteRetry(policy))
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actWriter.Bytes()); diff != "" {
+			t.Fatalf("TestWithWriteRetry() mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]int{1, 2}, backoffs); diff != "" {
+			t.Fatalf("TestWithWriteRetry() backoff mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("retries also apply to buffered writers", func(t *testing.T) {
+		t.Parallel()
+
+		actWriter := &flakyWriter{failures: 4}
+		policy := gzipstreamwriter.WriteRetryPolicy{MaxAttempts: 5}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterBuffered(actWriter, gzipstreamwriter.WithWriteRetry(policy))
+		if _, err := actGzipWriter.Write(input); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if diff := cmp.Diff(expBuffer.Bytes(), actWriter.Bytes()); diff != "" {
+			t.Fatalf("TestWithWriteRetry() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("exhausted retries are sticky", func(t *testing.T) {
+		t.Parallel()
+
+		actWriter := &flakyWriter{failures: 3}
+		policy := gzipstreamwriter.WriteRetryPolicy{MaxAttempts: 3}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(actWriter, gzipstreamwriter.WithWriteRetry(policy))
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, errTestRetryable) {
+			t.Fatalf("expected error %v, got %v", errTestRetryable, err)
+		}
+		if err := actGzipWriter.Err(); !errors.Is(err, errTestRetryable) {
+			t.Fatalf("expected sticky error %v, got %v", errTestRetryable, err)
+		}
+		if err := actGzipWriter.Close(); !errors.Is(err, errTestRetryable) {
+			t.Fatalf("expected error %v, got %v", errTestRetryable, err)
+		}
+	})
+
+	t.Run("non-retryable errors are not retried", func(t *testing.T) {
+		t.Parallel()
+
+		actWriter := &flakyWriter{failures: 1}
+		policy := gzipstreamwriter.WriteRetryPolicy{
+			MaxAttempts: 5,
+			Retryable:   func(error) bool { return false },
+		}
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriter(actWriter, gzipstreamwriter.WithWriteRetry(policy))
+		if _, err := actGzipWriter.WriteCompressed(blob); !errors.Is(err, errTestRetryable) {
+			t.Fatalf("expected error %v, got %v", errTestRetryable, err)
+		}
+		if actWriter.calls != 1 {
+			t.Fatalf("expected 1 write attempt, got %d", actWriter.calls)
+		}
+	})
+}
+
+// flakyWriter accepts only the first half of each write, and fails, for the
+// first few calls to Write.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+	calls    int
+}
+
+func (fw *flakyWriter) Write(p []byte) (int, error) {
+	fw.calls++
+	if fw.failures > 0 {
+		fw.failures--
+		n, _ := fw.Buffer.Write(p[:len(p)/2])
+		return n, errTestRetryable
+	}
+	return fw.Buffer.Write(p)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"hash"
+	"io"
+)
+
+// NewGzipStreamWriterTeeHash creates a new GzipStreamWriter with the default
+// compression level, that also writes all of its output to h, such as a
+// SHA-256 hash for content addressing. This saves a second pass over the
+// output. Once Close returns, h.Sum(nil) is the hash of the complete stream.
+//
+// Only the bytes that w accepted are hashed, so after a write error, h covers
+// exactly the output that reached w. The hash is kept across Reset and
+// SetWriter, but it is not reset by them, so call h.Reset to start over.
+func NewGzipStreamWriterTeeHash(w io.Writer, h hash.Hash, opts ...Option) *GzipStreamWriter {
+	z := new(GzipStreamWriter)
+	z.hash = h
+	z.applyOptions(opts)
+	z.init(w, DefaultCompression)
+	return z
+}
+
+// teeWriter writes to w, and copies the bytes that w accepted into h.
+type teeWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	// A hash.Hash never returns an error.
+	_, _ = t.h.Write(p[:n])
+	return n, err //nolint:wrapcheck
+}
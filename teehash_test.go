@@ -0,0 +1,92 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestNewGzipStreamWriterTeeHash(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		note string
+		opts []gzipstreamwriter.Option
+	}{
+		{note: "default"},
+		{note: "buffered", opts: []gzipstreamwriter.Option{gzipstreamwriter.WithWriteBuffer(4096)}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.note, func(t *testing.T) {
+			t.Parallel()
+
+			actBuffer := bytes.Buffer{}
+			h := sha256.New()
+			actGzipWriter := gzipstreamwriter.NewGzipStreamWriterTeeHash(&actBuffer, h, tc.opts...)
+			if _, err := actGzipWriter.Write(randomTestBytes(1000)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.WriteCompressed(compressStdlib(t, randomTestBytes(2000))); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := actGzipWriter.Write(randomTestBytes(3000)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := actGzipWriter.Close(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			expected := sha256.Sum256(actBuffer.Bytes())
+			if diff := cmp.Diff(expected[:], h.Sum(nil)); diff != "" {
+				t.Fatalf("TestNewGzipStreamWriterTeeHash() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("kept across SetWriter", func(t *testing.T) {
+		t.Parallel()
+
+		first := bytes.Buffer{}
+		second := bytes.Buffer{}
+		h := sha256.New()
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterTeeHash(&first, h)
+		if _, err := actGzipWriter.Write(randomTestBytes(1000)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Flush(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.SetWriter(&second); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.Write(randomTestBytes(2000)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		expected := sha256.Sum256(append(first.Bytes(), second.Bytes()...))
+		if diff := cmp.Diff(expected[:], h.Sum(nil)); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterTeeHash() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("failed write is not hashed", func(t *testing.T) {
+		t.Parallel()
+
+		h := sha256.New()
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterTeeHash(&failingWriter{}, h)
+		_, _ = actGzipWriter.Write(randomTestBytes(1000))
+		_ = actGzipWriter.Close()
+
+		expected := sha256.Sum256(nil)
+		if diff := cmp.Diff(expected[:], h.Sum(nil)); diff != "" {
+			t.Fatalf("TestNewGzipStreamWriterTeeHash() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2024, Philip Conrad.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package gzipstreamwriter
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// emptyFinalBlock is a byte-aligned DEFLATE block, with the final block bit
+// set, that holds no data: a fixed Huffman block, with just an end-of-block
+// code, padded out to the byte boundary.
+var emptyFinalBlock = []byte{0x03, 0x00}
+
+// GzipStreamWriterAt assembles pre-compressed gzip blobs into a single gzip
+// member in an [io.WriterAt], such as an [os.File]. Each blob is given its
+// offset in the output up front, so that blobs from several goroutines can
+// be written concurrently, instead of one after the other.
+//
+// The header goes at offset 0, and is written when the first blob is placed,
+// so the embedded Header must be set up before that. Blobs follow in the
+// order that their WriteCompressed calls start, and the final block and
+// trailer are written last by Close.
+//
+// Unlike GzipStreamWriter, a GzipStreamWriterAt only takes pre-compressed
+// blobs. It is safe for concurrent use, except for Close, which must only be
+// called once every WriteCompressed call has returned.
+type GzipStreamWriterAt struct {
+	gzip.Header // written at first WriteCompressed call, or Close
+
+	w           io.WriterAt
+	mu          sync.Mutex
+	wroteHeader bool
+	closed      bool
+	offset      int64  // Offset of the next blob.
+	digest      uint32 // CRC32 of the data placed so far.
+	size        uint32 // Length of the data placed so far, mod 2^32.
+	err         error
+}
+
+// NewGzipStreamWriterAt creates a new GzipStreamWriterAt, which writes its
+// output to w, starting at offset 0.
+func NewGzipStreamWriterAt(w io.WriterAt) *GzipStreamWriterAt {
+	return &GzipStreamWriterAt{
+		Header: gzip.Header{
+			OS: 255, // unknown
+		},
+		w: w,
+	}
+}
+
+// WriteCompressed places a single compressed gzip blob after the blobs placed
+// before it, and writes it to the underlying io.WriterAt. The blob is checked
+// and spliced like GzipStreamWriter.WriteCompressed does, before its offset
+// is reserved, so an invalid blob leaves no gap in the output.
+//
+// It returns the number of bytes written to the underlying io.WriterAt.
+// If that fails, the output has a hole in it, and the GzipStreamWriterAt is
+// left in a terminal error state.
+func (z *GzipStreamWriterAt) WriteCompressed(p []byte) (int, error) {
+	content, checksum, isize, err := TrimBlob(p)
+	if err != nil {
+		return 0, err
+	}
+	var buf [spliceTailSize + 1]byte
+	pieces, err := prepareDeflate(&buf, content, checksum, isize)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := z.reserve(piecesLength(pieces[:]), checksum, isize)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for _, piece := range pieces {
+		n, err := z.w.WriteAt(piece, offset+int64(written))
+		written += n
+		if err != nil {
+			return written, z.setErr(fmt.Errorf("gzip: failed to write blob at offset %d: %w", offset, err))
+		}
+	}
+	return written, nil
+}
+
+// reserve claims length bytes of output for a blob, and folds the blob's
+// trailer fields into the running CRC32 and size. The header is written first,
+// if it has not been yet. It returns the offset of the claimed bytes.
+func (z *GzipStreamWriterAt) reserve(length int, checksum, isize uint32) (int64, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if err := z.writeHeader(); err != nil {
+		return 0, err
+	}
+	offset := z.offset
+	z.offset += int64(length)
+	z.digest = crc32Combine(crc32.IEEETable, z.digest, checksum, int(isize))
+	z.size += isize
+	return offset, nil
+}
+
+// writeHeader writes the header at offset 0, if it has not been written yet.
+// z.mu must be held.
+func (z *GzipStreamWriterAt) writeHeader() error {
+	if z.wroteHeader {
+		return nil
+	}
+	z.wroteHeader = true
+
+	header, err := buildHeader(z.Header, xflForLevel(DefaultCompression), false)
+	if err != nil {
+		z.err = err
+		return z.err
+	}
+	if _, err := z.w.WriteAt(header, 0); err != nil {
+		z.err = fmt.Errorf("gzip: failed to write header: %w", err)
+		return z.err
+	}
+	z.offset = int64(len(header))
+	return nil
+}
+
+// setErr records err as the terminal error, unless there already is one.
+func (z *GzipStreamWriterAt) setErr(err error) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+	return z.err
+}
+
+// Size returns the total size of the output so far. After Close, it is the
+// size of the complete gzip stream.
+func (z *GzipStreamWriterAt) Size() int64 {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.offset
+}
+
+// Close writes the header (if no blobs were written), then the final block
+// and the trailer, after the last blob placed. It does not close the
+// underlying io.WriterAt.
+func (z *GzipStreamWriterAt) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+
+	end := make([]byte, 0, len(emptyFinalBlock)+8)
+	end = append(end, emptyFinalBlock...)
+	end = binary.LittleEndian.AppendUint32(end, z.digest)
+	end = binary.LittleEndian.AppendUint32(end, z.size)
+	if _, err := z.w.WriteAt(end, z.offset); err != nil {
+		z.err = fmt.Errorf("gzip: failed to write trailer: %w", err)
+		return z.err
+	}
+	z.offset += int64(len(end))
+	return nil
+}
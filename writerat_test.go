@@ -0,0 +1,171 @@
+package gzipstreamwriter_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/philipaconrad/gzipstreamwriter"
+)
+
+func TestGzipStreamWriterAt(t *testing.T) {
+	t.Parallel()
+
+	input := randomTestBytes(300_000)
+	var blobs [][]byte
+	for chunk := range slices.Chunk(input, 100_000) {
+		blobs = append(blobs, compressStdlib(t, chunk))
+	}
+
+	t.Run("sequential blobs", func(t *testing.T) {
+		t.Parallel()
+
+		file := createTempFile(t)
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterAt(file)
+		actGzipWriter.Name = "data.bin"
+		for _, blob := range blobs {
+			if _, err := actGzipWriter.WriteCompressed(blob); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		output := readTempFile(t, file)
+		if actGzipWriter.Size() != int64(len(output)) {
+			t.Fatalf("expected size %d, got %d", len(output), actGzipWriter.Size())
+		}
+		gzReader, err := gzip.NewReader(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if gzReader.Name != "data.bin" {
+			t.Fatalf("expected name %q, got %q", "data.bin", gzReader.Name)
+		}
+		actual, err := io.ReadAll(gzReader)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff := cmp.Diff(input, actual); diff != "" {
+			t.Fatalf("TestGzipStreamWriterAt() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("concurrent blobs", func(t *testing.T) {
+		t.Parallel()
+
+		// Each blob is one line, so the lines can be checked regardless of
+		// the order the blobs were placed in.
+		var lines []string
+		for i := range 64 {
+			lines = append(lines, fmt.Sprintf("line %d: %s\n", i, strings.Repeat("x", i*100)))
+		}
+
+		file := createTempFile(t)
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterAt(file)
+		var wg sync.WaitGroup
+		errs := make([]error, len(lines))
+		for i, line := range lines {
+			blob := compressStdlib(t, []byte(line))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = actGzipWriter.WriteCompressed(blob)
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		actual, err := gzipstreamwriter.DecompressAll(bytes.NewReader(readTempFile(t, file)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		actualLines := strings.SplitAfter(string(actual), "\n")
+		actualLines = actualLines[:len(actualLines)-1]
+		slices.Sort(actualLines)
+		slices.Sort(lines)
+		if diff := cmp.Diff(lines, actualLines); diff != "" {
+			t.Fatalf("TestGzipStreamWriterAt() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("no blobs", func(t *testing.T) {
+		t.Parallel()
+
+		file := createTempFile(t)
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterAt(file)
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		actual, err := gzipstreamwriter.DecompressAll(bytes.NewReader(readTempFile(t, file)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(actual) != 0 {
+			t.Fatalf("expected no output, got %d bytes", len(actual))
+		}
+	})
+
+	t.Run("invalid blob", func(t *testing.T) {
+		t.Parallel()
+
+		file := createTempFile(t)
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterAt(file)
+		if _, err := actGzipWriter.WriteCompressed([]byte("not a gzip blob at all")); !errors.Is(err, gzipstreamwriter.ErrBlob) {
+			t.Fatalf("expected ErrBlob, got %v", err)
+		}
+		if actGzipWriter.Size() != 0 {
+			t.Fatalf("expected size 0, got %d", actGzipWriter.Size())
+		}
+	})
+
+	t.Run("write after close", func(t *testing.T) {
+		t.Parallel()
+
+		file := createTempFile(t)
+		actGzipWriter := gzipstreamwriter.NewGzipStreamWriterAt(file)
+		if err := actGzipWriter.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := actGzipWriter.WriteCompressed(blobs[0]); !errors.Is(err, gzipstreamwriter.ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	})
+}
+
+// createTempFile creates a file in a temporary directory, which is removed
+// when the test ends.
+func createTempFile(t *testing.T) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "output.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = file.Close() })
+	return file
+}
+
+// readTempFile reads back the whole contents of file.
+func readTempFile(t *testing.T, file *os.File) []byte {
+	t.Helper()
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}